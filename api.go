@@ -0,0 +1,405 @@
+package etcdhosts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/miekg/dns"
+)
+
+// api is the optional http server used to manage the hosts entries stored in etcd.
+type api struct {
+	*Hostsfile
+
+	// listen address of the http server
+	addr string
+
+	mux *http.ServeMux
+	srv *http.Server
+	ln  net.Listener
+}
+
+func newAPI(addr string, h *Hostsfile) *api {
+	a := &api{Hostsfile: h, addr: addr}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/records/", a.handleRecords)
+	mux.HandleFunc("/import", a.handleImport)
+	mux.HandleFunc("/export", a.handleExport)
+	mux.HandleFunc("/validate", a.handleValidate)
+	mux.HandleFunc("/names", a.handleNames)
+	mux.HandleFunc("/debug", a.handleDebug)
+	a.mux = mux
+	return a
+}
+
+// OnStartup starts the http server, it is also called to start it again when a restart failed.
+func (a *api) OnStartup() error {
+	ln, err := net.Listen("tcp", a.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen api address [%s]: %s", a.addr, err.Error())
+	}
+	a.ln = ln
+
+	// a closed http.Server can't serve again
+	a.srv = &http.Server{Handler: a.mux}
+	go func() { _ = a.srv.Serve(ln) }()
+	return nil
+}
+
+// OnFinalShutdown stops the http server, it is also called before a restart so the new instance
+// can listen on the same address.
+func (a *api) OnFinalShutdown() error {
+	if a.ln == nil {
+		return nil
+	}
+	_ = a.srv.Close()
+	// The server only closes the listener once Serve has started, the address must be free
+	// when this returns.
+	_ = a.ln.Close()
+	a.ln = nil
+	return nil
+}
+
+// handleRecords serves PUT and DELETE on /records/{name}/{type}.
+func (a *api) handleRecords(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/records/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		http.Error(w, "path must be /records/{name}/{type}", http.StatusNotFound)
+		return
+	}
+
+	name := normalizeName(parts[0])
+	if _, ok := dns.IsDomainName(name); !ok {
+		http.Error(w, fmt.Sprintf("invalid name '%s'", parts[0]), http.StatusBadRequest)
+		return
+	}
+	if plugin.Zones(a.Origins).Matches(name) == "" {
+		http.Error(w, fmt.Sprintf("name '%s' is not in zones %v", parts[0], a.Origins), http.StatusBadRequest)
+		return
+	}
+
+	family := 0
+	switch strings.ToUpper(parts[1]) {
+	case "A":
+		family = 1
+	case "AAAA":
+		family = 2
+	default:
+		http.Error(w, fmt.Sprintf("unsupported type '%s'", parts[1]), http.StatusBadRequest)
+		return
+	}
+
+	var ips []net.IP
+	switch r.Method {
+	case http.MethodPut:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, f := range strings.Fields(string(body)) {
+			ip := parseIP(f)
+			if ip == nil || ipFamily(ip) != family {
+				http.Error(w, fmt.Sprintf("invalid %s address '%s'", strings.ToUpper(parts[1]), f), http.StatusBadRequest)
+				return
+			}
+			ips = append(ips, ip)
+		}
+		if len(ips) == 0 {
+			http.Error(w, "request body needs at least one address", http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+	default:
+		w.Header().Set("Allow", "PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	found := false
+	err := a.modifyHosts(r.Context(), func(data []byte) ([]byte, bool) {
+		var newData []byte
+		newData, found = updateHosts(data, name, family, ips)
+		return newData, found || len(ips) > 0
+	})
+	if err != nil {
+		log.Errorf("failed to update etcd key [%s]: %s", a.etcdHostsKey, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if r.Method == http.MethodDelete && !found {
+		http.Error(w, "record not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// importSummary is the response of a zone file import.
+type importSummary struct {
+	Imported int            `json:"imported"`
+	Skipped  map[string]int `json:"skipped"`
+}
+
+// handleImport serves POST on /import, the request body is a RFC 1035 zone file whose A and AAAA
+// records replace the addresses of the same names in etcd. The origin query parameter sets the
+// initial $ORIGIN of the zone file.
+func (a *api) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	origin := r.URL.Query().Get("origin")
+	if origin != "" {
+		origin = normalizeName(origin)
+		if _, ok := dns.IsDomainName(origin); !ok {
+			http.Error(w, fmt.Sprintf("invalid origin '%s'", r.URL.Query().Get("origin")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	summary := importSummary{Skipped: make(map[string]int)}
+	// keyed by name, then by family
+	records := make(map[string]map[int][]net.IP)
+	var names []string
+
+	zp := dns.NewZoneParser(http.MaxBytesReader(w, r.Body, 16*1024*1024), origin, "")
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip = rr.A
+		case *dns.AAAA:
+			ip = rr.AAAA
+		default:
+			summary.Skipped[dns.TypeToString[rr.Header().Rrtype]]++
+			continue
+		}
+
+		name := plugin.Name(rr.Header().Name).Normalize()
+		if plugin.Zones(a.Origins).Matches(name) == "" {
+			summary.Skipped[dns.TypeToString[rr.Header().Rrtype]]++
+			continue
+		}
+		if _, ok := records[name]; !ok {
+			records[name] = make(map[int][]net.IP)
+			names = append(names, name)
+		}
+		records[name][ipFamily(ip)] = append(records[name][ipFamily(ip)], ip)
+		summary.Imported++
+	}
+	if err := zp.Err(); err != nil {
+		http.Error(w, fmt.Sprintf("invalid zone file: %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	if summary.Imported > 0 {
+		err := a.modifyHosts(r.Context(), func(data []byte) ([]byte, bool) {
+			for _, name := range names {
+				for _, family := range []int{1, 2} {
+					if ips := records[name][family]; len(ips) > 0 {
+						data, _ = updateHosts(data, name, family, ips)
+					}
+				}
+			}
+			return data, true
+		})
+		if err != nil {
+			log.Errorf("failed to update etcd key [%s]: %s", a.etcdHostsKey, err.Error())
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(summary)
+}
+
+// handleExport serves GET on /export, it dumps the hosts data stored in etcd as a RFC 1035 zone
+// file. Lines that can not be exported are reported as comments at the end of the zone file.
+func (a *api) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.etcdTimeout)
+	defer cancel()
+	getResp, err := a.client().Get(ctx, a.etcdHostsKey)
+	if err != nil {
+		log.Errorf("failed to get etcd key [%s]: %s", a.etcdHostsKey, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if len(getResp.Kvs) == 0 {
+		http.Error(w, fmt.Sprintf("etcd key [%s] not found", a.etcdHostsKey), http.StatusNotFound)
+		return
+	}
+
+	data := getResp.Kvs[0].Value
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		addr, names, err := parseLine(scanner.Bytes())
+		if addr == nil || err != nil {
+			continue
+		}
+
+		for _, n := range names {
+			name := normalizeName(string(n))
+			if plugin.Zones(a.Origins).Matches(name) == "" {
+				continue
+			}
+
+			var rr dns.RR
+			if ipFamily(addr) == 1 {
+				hdr := dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: a.options.ttlOf(dns.TypeA)}
+				rr = &dns.A{Hdr: hdr, A: addr}
+			} else {
+				hdr := dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: a.options.ttlOf(dns.TypeAAAA)}
+				rr = &dns.AAAA{Hdr: hdr, AAAA: addr}
+			}
+			buf.WriteString(rr.String() + "\n")
+		}
+	}
+	for _, p := range validateHosts(data, a.Origins) {
+		buf.WriteString("; skipped " + p.String() + "\n")
+	}
+
+	w.Header().Set("Content-Type", "text/dns")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// handleValidate serves GET on /validate, it reports the entries of the hosts data stored in
+// etcd that are ignored as a JSON list of problems.
+func (a *api) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.etcdTimeout)
+	defer cancel()
+	problems, err := ValidateHosts(ctx, a.client(), a.etcdHostsKey, a.Origins)
+	if err != nil {
+		log.Errorf("failed to validate etcd key [%s]: %s", a.etcdHostsKey, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if problems == nil {
+		problems = []Problem{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(problems)
+}
+
+// handleNames serves GET on /names, it returns the sorted names in the hosts data stored in
+// etcd as a JSON list.
+func (a *api) handleNames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.etcdTimeout)
+	defer cancel()
+	names, err := ListNames(ctx, a.client(), a.etcdHostsKey, a.Origins)
+	if err != nil {
+		log.Errorf("failed to list names of etcd key [%s]: %s", a.etcdHostsKey, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if names == nil {
+		names = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(names)
+}
+
+// debugLine is a line of the hosts data stored in etcd.
+type debugLine struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// debugInfo is the response of a name lookup on /debug.
+type debugInfo struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// version of the etcd key, 0 if the key is not found
+	Version int64 `json:"version"`
+	// lines of the hosts data in etcd that have the name
+	Lines []debugLine `json:"lines"`
+	// addresses currently answered for the name
+	A    []string `json:"a"`
+	AAAA []string `json:"aaaa"`
+}
+
+// handleDebug serves GET on /debug?name={name}, it returns the lines of the hosts data stored in
+// etcd that have the name next to the addresses that are answered for it, so an entry that doesn't
+// resolve as expected can be traced without attaching a debugger.
+func (a *api) handleDebug(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := normalizeName(r.URL.Query().Get("name"))
+	if _, ok := dns.IsDomainName(name); !ok || name == "." {
+		http.Error(w, fmt.Sprintf("invalid name '%s'", r.URL.Query().Get("name")), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), a.etcdTimeout)
+	defer cancel()
+	getResp, err := a.client().Get(ctx, a.etcdHostsKey)
+	if err != nil {
+		log.Errorf("failed to get etcd key [%s]: %s", a.etcdHostsKey, err.Error())
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	info := debugInfo{Name: name, Key: a.etcdHostsKey, Lines: []debugLine{}, A: []string{}, AAAA: []string{}}
+	if len(getResp.Kvs) > 0 {
+		info.Version = getResp.Kvs[0].Version
+
+		n := 0
+		scanner := bufio.NewScanner(bytes.NewReader(getResp.Kvs[0].Value))
+		for scanner.Scan() {
+			n++
+			_, names, _ := parseLine(scanner.Bytes())
+			for _, f := range names {
+				if normalizeName(string(f)) == name {
+					info.Lines = append(info.Lines, debugLine{Line: n, Text: string(bytes.TrimSpace(scanner.Bytes()))})
+					break
+				}
+			}
+		}
+	}
+	for _, ip := range a.LookupStaticHostV4(name) {
+		info.A = append(info.A, ip.String())
+	}
+	for _, ip := range a.LookupStaticHostV6(name) {
+		info.AAAA = append(info.AAAA, ip.String())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(info)
+}
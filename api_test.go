@@ -0,0 +1,77 @@
+package etcdhosts
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// zoneRecords returns the sorted records of the exported zone file, without the comments.
+func zoneRecords(zone string) []string {
+	var records []string
+	for _, line := range strings.Split(zone, "\n") {
+		if line != "" && !strings.HasPrefix(line, ";") {
+			records = append(records, line)
+		}
+	}
+	sort.Strings(records)
+	return records
+}
+
+// export gets the zone file of srv.
+func export(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	resp, err := http.Get(srv.URL + "/export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected export status 200, got %d: %s", resp.StatusCode, body)
+	}
+	return string(body)
+}
+
+func TestAPIExportImport(t *testing.T) {
+	from, _ := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	fromSrv := httptest.NewServer(newAPI("", newTestHostsfile(from)).mux)
+	defer fromSrv.Close()
+
+	to, toKV := newFakeClient(nil)
+	toSrv := httptest.NewServer(newAPI("", newTestHostsfile(to)).mux)
+	defer toSrv.Close()
+
+	zone := export(t, fromSrv)
+	if !strings.Contains(zone, "; skipped") {
+		t.Errorf("expected the localhost entries to be reported as skipped, got %q", zone)
+	}
+
+	resp, err := http.Post(toSrv.URL+"/import", "text/dns", strings.NewReader(zone))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected import status 200, got %d: %s", resp.StatusCode, body)
+	}
+	if !strings.Contains(string(body), `"imported":4`) {
+		t.Errorf("expected 4 imported records, got %s", body)
+	}
+	if toKV.kvs[testHostsKey] == nil {
+		t.Fatalf("expected the import to write etcd key [%s]", testHostsKey)
+	}
+
+	expected, got := zoneRecords(zone), zoneRecords(export(t, toSrv))
+	if strings.Join(expected, "\n") != strings.Join(got, "\n") {
+		t.Errorf("expected the exported records\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
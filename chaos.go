@@ -0,0 +1,108 @@
+package etcdhosts
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// debugStatusCache is how long the etcd endpoint status of version.etcdhosts is reused.
+const debugStatusCache = 5 * time.Second
+
+// chaos returns the answer for a CH class TXT query for the server version or identity,
+// nil is returned for any other query.
+func (h Hosts) chaos(ctx context.Context, state request.Request) []dns.RR {
+	if state.QClass() != dns.ClassCHAOS || state.QType() != dns.TypeTXT {
+		return nil
+	}
+
+	var txt []string
+	switch state.Name() {
+	case "version.bind.", "version.server.":
+		if h.options.chaosVersion == "" {
+			return nil
+		}
+		txt = []string{h.options.chaosVersion}
+	case "hostname.bind.", "id.server.":
+		if h.options.chaosVersion == "" {
+			return nil
+		}
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "localhost"
+		}
+		txt = []string{hostname}
+	case "version.etcdhosts.":
+		if !h.options.chaosDebug {
+			return nil
+		}
+		txt = h.debugStatus(ctx)
+	default:
+		return nil
+	}
+
+	answers := make([]dns.RR, len(txt))
+	for i := range txt {
+		r := new(dns.TXT)
+		r.Hdr = dns.RR_Header{Name: state.QName(), Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS, Ttl: 0}
+		r.Txt = []string{txt[i]}
+		answers[i] = r
+	}
+	return answers
+}
+
+// debugStatus returns the plugin version, the loaded hosts key version and the status of
+// every etcd endpoint.
+func (h Hosts) debugStatus(ctx context.Context) []string {
+	v := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range info.Deps {
+			if m.Path == "github.com/ytpay/etcdhosts" {
+				v = m.Version
+			}
+		}
+	}
+
+	h.RLock()
+	keyVersion := h.etcdKeyVersion
+	h.RUnlock()
+
+	status := []string{
+		fmt.Sprintf("etcdhosts %s", v),
+		fmt.Sprintf("key %s version %d", h.etcdHostsKey, keyVersion),
+	}
+
+	return append(status, h.endpointStatus(ctx)...)
+}
+
+// endpointStatus returns the status of every etcd endpoint. The status is cached for a few
+// seconds, so debug queries can't turn into a flood of etcd requests.
+func (h Hosts) endpointStatus(ctx context.Context) []string {
+	h.statusLock.Lock()
+	defer h.statusLock.Unlock()
+	if time.Since(h.statusTime) < debugStatusCache {
+		return h.statusCache
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.etcdTimeout)
+	defer cancel()
+	var status []string
+	cli := h.client()
+	for _, ep := range cli.Endpoints() {
+		resp, err := cli.Status(ctx, ep)
+		if err != nil {
+			status = append(status, fmt.Sprintf("%s unreachable: %s", ep, err.Error()))
+			continue
+		}
+		status = append(status, fmt.Sprintf("%s ok, etcd %s revision %d", ep, resp.Version, resp.Header.Revision))
+	}
+
+	h.statusCache, h.statusTime = status, time.Now()
+	return status
+}
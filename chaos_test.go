@@ -0,0 +1,69 @@
+package etcdhosts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSChaosVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		answer  bool
+	}{
+		{"etcdhosts 1.0", true},
+		// version queries go to the next plugin when no version is configured
+		{"", false},
+	}
+
+	for _, tc := range tests {
+		h := newTestHosts(hostsExample)
+		h.options.chaosVersion = tc.version
+
+		m := new(dns.Msg)
+		m.SetQuestion("version.bind.", dns.TypeTXT)
+		m.Question[0].Qclass = dns.ClassCHAOS
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if !tc.answer {
+			if rec.Msg != nil {
+				t.Errorf("%q: expected the query to go to the next plugin, got %s", tc.version, rec.Msg)
+			}
+			continue
+		}
+		if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+			t.Fatalf("%q: expected one answer, got %v", tc.version, rec.Msg)
+		}
+		txt, ok := rec.Msg.Answer[0].(*dns.TXT)
+		if !ok || txt.Hdr.Class != dns.ClassCHAOS || len(txt.Txt) != 1 || txt.Txt[0] != tc.version {
+			t.Errorf("%q: expected a CH TXT record with the version, got %s", tc.version, rec.Msg.Answer[0])
+		}
+	}
+}
+
+func TestServeDNSOtherClass(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.options.chaosVersion = "etcdhosts 1.0"
+
+	for _, class := range []uint16{dns.ClassHESIOD, dns.ClassCHAOS} {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.org.", dns.TypeA)
+		m.Question[0].Qclass = class
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		// the next plugin gets the query, it doesn't write a reply
+		if rec.Msg != nil {
+			t.Errorf("%s: expected no answer from the IN data, got %s", dns.ClassToString[class], rec.Msg)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package etcdhosts
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// discoverEndpoints returns the etcd endpoints of the targets of the SRV record name, https
+// endpoints are returned when the etcd client uses tls.
+func (h *Hostsfile) discoverEndpoints() ([]string, error) {
+	_, addrs, err := h.lookupSRV("", "", h.etcdDiscoverSRV)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "http://"
+	if h.etcdTLSConfig != nil {
+		scheme = "https://"
+	}
+	var endpoints []string
+	for _, addr := range addrs {
+		host := strings.TrimSuffix(addr.Target, ".")
+		endpoints = append(endpoints, scheme+net.JoinHostPort(host, strconv.Itoa(int(addr.Port))))
+	}
+	sort.Strings(endpoints)
+	return endpoints, nil
+}
+
+// refreshEndpoints looks up the SRV record again and updates the endpoints of the etcd client
+// when they changed.
+func (h *Hostsfile) refreshEndpoints() {
+	endpoints, err := h.discoverEndpoints()
+	if err != nil {
+		log.Errorf("failed to discover etcd endpoints [%s]: %s", h.etcdDiscoverSRV, err.Error())
+		return
+	}
+	if len(endpoints) == 0 || strings.Join(endpoints, ",") == strings.Join(h.etcdEndpoints, ",") {
+		return
+	}
+
+	log.Infof("etcd endpoints changed to %v", endpoints)
+	h.etcdEndpoints = endpoints
+	h.client().SetEndpoints(endpoints...)
+}
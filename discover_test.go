@@ -0,0 +1,55 @@
+package etcdhosts
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeLookupSRV returns a SRV lookup that answers name with addrs.
+func fakeLookupSRV(name string, addrs []*net.SRV) func(service, proto, name string) (string, []*net.SRV, error) {
+	return func(_, _, n string) (string, []*net.SRV, error) {
+		if n != name {
+			return "", nil, errors.New("no such host")
+		}
+		return n, addrs, nil
+	}
+}
+
+func TestDiscoverEndpoints(t *testing.T) {
+	addrs := []*net.SRV{
+		{Target: "etcd-1.etcd.svc.", Port: 2379},
+		{Target: "etcd-0.etcd.svc.", Port: 2379},
+	}
+
+	tests := []struct {
+		name      string
+		tls       bool
+		endpoints []string
+		err       bool
+	}{
+		{"_etcd-client._tcp.etcd.svc", false, []string{"http://etcd-0.etcd.svc:2379", "http://etcd-1.etcd.svc:2379"}, false},
+		{"_etcd-client._tcp.etcd.svc", true, []string{"https://etcd-0.etcd.svc:2379", "https://etcd-1.etcd.svc:2379"}, false},
+		{"_etcd-client._tcp.missing.svc", false, nil, true},
+	}
+
+	for _, tc := range tests {
+		h := newTestHostsfile(nil)
+		h.etcdDiscoverSRV = tc.name
+		h.lookupSRV = fakeLookupSRV("_etcd-client._tcp.etcd.svc", addrs)
+		if tc.tls {
+			h.etcdTLSConfig = &tls.Config{}
+		}
+
+		endpoints, err := h.discoverEndpoints()
+		if tc.err != (err != nil) {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.err, err)
+			continue
+		}
+		if !reflect.DeepEqual(endpoints, tc.endpoints) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.endpoints, endpoints)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package etcdhosts
+
+import (
+	"net"
+)
+
+// dns64Prefix is the default NAT64 prefix of RFC 6052.
+const dns64Prefix = "64:ff9b::/96"
+
+// synthAAAA embeds the IPv4 addresses into the NAT64 prefix as in RFC 6052 section 2.2,
+// prefix lengths of 32, 40, 48, 56, 64 and 96 are valid.
+func synthAAAA(prefix *net.IPNet, ips []net.IP) []net.IP {
+	ones, _ := prefix.Mask.Size()
+	n := ones / 8
+
+	var out []net.IP
+	for _, ip := range ips {
+		ip4 := ip.To4()
+		if ip4 == nil {
+			continue
+		}
+
+		ip6 := make(net.IP, net.IPv6len)
+		copy(ip6, prefix.IP.To16()[:n])
+		// bits 64 to 71 are reserved and must be zero, the address continues after them
+		j := n
+		for _, b := range ip4 {
+			if j == 8 {
+				j++
+			}
+			ip6[j] = b
+			j++
+		}
+		out = append(out, ip6)
+	}
+	return out
+}
+
+// validDNS64Prefix reports whether prefix is an IPv6 network with a RFC 6052 prefix length.
+func validDNS64Prefix(prefix *net.IPNet) bool {
+	if prefix.IP.To4() != nil {
+		return false
+	}
+	switch ones, bits := prefix.Mask.Size(); {
+	case bits != 8*net.IPv6len:
+		return false
+	case ones == 32, ones == 40, ones == 48, ones == 56, ones == 64, ones == 96:
+		return true
+	}
+	return false
+}
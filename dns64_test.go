@@ -0,0 +1,59 @@
+package etcdhosts
+
+import (
+	"net"
+	"testing"
+)
+
+func TestSynthAAAA(t *testing.T) {
+	// the examples of RFC 6052 section 2.4
+	tests := []struct {
+		prefix, expected string
+	}{
+		{"2001:db8::/32", "2001:db8:c000:221::"},
+		{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+		{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+		{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+		{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+		{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+		{dns64Prefix, "64:ff9b::192.0.2.33"},
+	}
+
+	for _, tc := range tests {
+		_, prefix, err := net.ParseCIDR(tc.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ips := synthAAAA(prefix, []net.IP{net.ParseIP("192.0.2.33"), net.ParseIP("2001:db8::1")})
+		if len(ips) != 1 {
+			t.Fatalf("%s: expected 1 address, got %v", tc.prefix, ips)
+		}
+		if !ips[0].Equal(net.ParseIP(tc.expected)) {
+			t.Errorf("%s: expected %s, got %s", tc.prefix, tc.expected, ips[0])
+		}
+	}
+}
+
+func TestValidDNS64Prefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		valid  bool
+	}{
+		{"64:ff9b::/96", true},
+		{"2001:db8::/32", true},
+		{"2001:db8::/64", true},
+		{"2001:db8::/36", false},
+		{"2001:db8::/128", false},
+		{"10.0.0.0/8", false},
+	}
+
+	for _, tc := range tests {
+		_, prefix, err := net.ParseCIDR(tc.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := validDNS64Prefix(prefix); got != tc.valid {
+			t.Errorf("%s: expected valid %t, got %t", tc.prefix, tc.valid, got)
+		}
+	}
+}
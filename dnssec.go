@@ -0,0 +1,162 @@
+package etcdhosts
+
+import (
+	"crypto"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// signatures are valid for a week and renewed after a day
+	sigValidity = 7 * 24 * time.Hour
+	sigRenewal  = 24 * time.Hour
+	// signatures start to be valid a bit in the past to allow for clock skew
+	sigSkew = 3 * time.Hour
+
+	// maximum number of cached signatures, the cache is reset when it is full
+	sigCacheSize = 10000
+)
+
+// dnssec signs answers with a zone signing key.
+type dnssec struct {
+	sync.Mutex
+
+	key    *dns.DNSKEY
+	signer crypto.Signer
+
+	// signatures keyed by the signed RRset
+	cache map[string]*dns.RRSIG
+}
+
+// newDNSSEC reads the key pair base.key and base.private as written by dnssec-keygen.
+func newDNSSEC(base string) (*dnssec, error) {
+	base = strings.TrimSuffix(strings.TrimSuffix(base, ".key"), ".private")
+
+	f, err := os.Open(base + ".key")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rr, err := dns.ReadRR(f, base+".key")
+	if err != nil {
+		return nil, err
+	}
+	key, ok := rr.(*dns.DNSKEY)
+	if !ok {
+		return nil, fmt.Errorf("%s.key is not a DNSKEY record", base)
+	}
+	key.Hdr.Name = plugin.Name(key.Hdr.Name).Normalize()
+
+	p, err := os.Open(base + ".private")
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+	privateKey, err := key.ReadPrivateKey(p, base+".private")
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s.private is not a signing key", base)
+	}
+
+	return &dnssec{key: key, signer: signer, cache: make(map[string]*dns.RRSIG)}, nil
+}
+
+// dnskey returns the DNSKEY answer if qname is the owner of the key.
+func (d *dnssec) dnskey(qname string, ttl uint32) []dns.RR {
+	if qname != d.key.Hdr.Name {
+		return nil
+	}
+	k := dns.Copy(d.key).(*dns.DNSKEY)
+	k.Hdr.Name = qname
+	k.Hdr.Ttl = ttl
+	return []dns.RR{k}
+}
+
+// blackLie returns the NSEC record of a negative answer for qname as the dnssec plugin makes it:
+// the next name is the immediate successor of qname, so no other name is disclosed, and a name
+// that doesn't exist is denied as a name that only has the types of the bitmap (RFC 4470).
+func blackLie(qname string, ttl uint32, types []uint16) dns.RR {
+	return &dns.NSEC{
+		Hdr:        dns.RR_Header{Name: qname, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: ttl},
+		NextDomain: "\\000." + qname,
+		TypeBitMap: types,
+	}
+}
+
+// sign appends a RRSIG for every RRset in rrs that is below the owner of the key.
+func (d *dnssec) sign(rrs []dns.RR) []dns.RR {
+	var order []string
+	rrsets := make(map[string][]dns.RR)
+	for _, rr := range rrs {
+		if !dns.IsSubDomain(d.key.Hdr.Name, rr.Header().Name) {
+			continue
+		}
+		k := rr.Header().Name + "/" + dns.TypeToString[rr.Header().Rrtype]
+		if _, ok := rrsets[k]; !ok {
+			order = append(order, k)
+		}
+		rrsets[k] = append(rrsets[k], rr)
+	}
+
+	for _, k := range order {
+		sig, err := d.signature(rrsets[k])
+		if err != nil {
+			log.Errorf("failed to sign %s: %s", k, err.Error())
+			continue
+		}
+		rrs = append(rrs, sig)
+	}
+	return rrs
+}
+
+// signature returns the cached signature of rrset, a new signature is made if there is none or
+// the cached one is due for renewal.
+func (d *dnssec) signature(rrset []dns.RR) (*dns.RRSIG, error) {
+	// The signature covers the RRset in canonical order, so every order of the records the
+	// answers are shuffled into shares it.
+	records := make([]string, len(rrset))
+	for i, rr := range rrset {
+		records[i] = rr.String()
+	}
+	sort.Strings(records)
+	k := strings.Join(records, "\n")
+	now := time.Now()
+
+	d.Lock()
+	sig, ok := d.cache[k]
+	d.Unlock()
+	if ok && now.Before(time.Unix(int64(sig.Inception), 0).Add(sigSkew+sigRenewal)) {
+		return sig, nil
+	}
+
+	sig = &dns.RRSIG{
+		Hdr:        dns.RR_Header{Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+		KeyTag:     d.key.KeyTag(),
+		Algorithm:  d.key.Algorithm,
+		SignerName: d.key.Hdr.Name,
+		Inception:  uint32(now.Add(-sigSkew).Unix()),
+		Expiration: uint32(now.Add(sigValidity).Unix()),
+	}
+	if err := sig.Sign(d.signer, rrset); err != nil {
+		return nil, err
+	}
+
+	d.Lock()
+	if len(d.cache) >= sigCacheSize {
+		d.cache = make(map[string]*dns.RRSIG)
+	}
+	d.cache[k] = sig
+	d.Unlock()
+	return sig, nil
+}
@@ -0,0 +1,152 @@
+package etcdhosts
+
+import (
+	"context"
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coredns/caddy"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// newTestDNSSEC returns a dnssec with a new ECDSA key for zone.
+func newTestDNSSEC(t *testing.T, zone string) *dnssec {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     257,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	privateKey, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &dnssec{key: key, signer: privateKey.(crypto.Signer), cache: make(map[string]*dns.RRSIG)}
+}
+
+// verify checks that every RRset of rrs other than RRSIGs is signed with the key of d.
+func verify(t *testing.T, d *dnssec, rrs []dns.RR) {
+	t.Helper()
+	rrsets := make(map[uint16][]dns.RR)
+	sigs := make(map[uint16]*dns.RRSIG)
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			sigs[sig.TypeCovered] = sig
+			continue
+		}
+		rrsets[rr.Header().Rrtype] = append(rrsets[rr.Header().Rrtype], rr)
+	}
+	for rrtype, rrset := range rrsets {
+		sig, ok := sigs[rrtype]
+		if !ok {
+			t.Errorf("expected a RRSIG for %s", dns.TypeToString[rrtype])
+			continue
+		}
+		if err := sig.Verify(d.key, rrset); err != nil {
+			t.Errorf("expected a valid RRSIG for %s, got %s", dns.TypeToString[rrtype], err)
+		}
+	}
+}
+
+func TestServeDNSSECNegative(t *testing.T) {
+	tests := []struct {
+		qname string
+		qtype uint16
+		types []uint16
+	}{
+		// the name only has an IPv4 address
+		{"example.org.", dns.TypeAAAA, []uint16{dns.TypeA, dns.TypeNS, dns.TypeSOA, dns.TypeRRSIG, dns.TypeNSEC, dns.TypeDNSKEY}},
+		{"www.example.org.", dns.TypeMX, []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeRRSIG, dns.TypeNSEC}},
+		// a name that doesn't exist is a black lie instead of NXDOMAIN
+		{"missing.example.org.", dns.TypeA, []uint16{dns.TypeRRSIG, dns.TypeNSEC}},
+	}
+
+	h := newTestHosts(hostsExample)
+	h.options.nameservers = []string{"ns1.example.org."}
+	h.dnssec = newTestDNSSEC(t, "example.org.")
+
+	for _, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		m.SetEdns0(4096, true)
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 0 {
+			t.Errorf("%s %s: expected a NODATA reply, got %s", tc.qname, dns.TypeToString[tc.qtype], rec.Msg)
+			continue
+		}
+
+		var nsec *dns.NSEC
+		for _, rr := range rec.Msg.Ns {
+			if rr, ok := rr.(*dns.NSEC); ok {
+				nsec = rr
+			}
+		}
+		if nsec == nil {
+			t.Errorf("%s %s: expected a NSEC record, got %v", tc.qname, dns.TypeToString[tc.qtype], rec.Msg.Ns)
+			continue
+		}
+		if nsec.Hdr.Name != tc.qname || nsec.NextDomain != "\\000."+tc.qname {
+			t.Errorf("%s %s: expected a NSEC black lie, got %s", tc.qname, dns.TypeToString[tc.qtype], nsec)
+		}
+		if !reflect.DeepEqual(nsec.TypeBitMap, tc.types) {
+			t.Errorf("%s %s: expected the types %v, got %v", tc.qname, dns.TypeToString[tc.qtype], tc.types, nsec.TypeBitMap)
+		}
+		verify(t, h.dnssec, rec.Msg.Ns)
+	}
+}
+
+func TestServeDNSSECAuthority(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.options.nameservers = []string{"ns1.example.org.", "ns2.example.org."}
+	h.dnssec = newTestDNSSEC(t, "example.org.")
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeA)
+	m.SetEdns0(4096, true)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	verify(t, h.dnssec, rec.Msg.Answer)
+	// the NS RRset of the authority section is signed too
+	verify(t, h.dnssec, rec.Msg.Ns)
+	if len(rec.Msg.Ns) != 3 {
+		t.Errorf("expected 2 NS records and their RRSIG, got %v", rec.Msg.Ns)
+	}
+}
+
+func TestSetupDNSSECKeyOwner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := newTestDNSSEC(t, "example.net.")
+	base := filepath.Join(dir, "Kexample.net.+013+00000")
+	if err := ioutil.WriteFile(base+".key", []byte(d.key.String()+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(base+".private", []byte(d.key.PrivateKeyString(d.signer)), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := caddy.NewTestController("dns", "etcdhosts example.org {\n dnssec_key "+base+"\n}")
+	if _, err := hostsParse(c); err == nil || !strings.Contains(err.Error(), "not in zones") {
+		t.Errorf("expected a key owner outside of the zones to be rejected, got %v", err)
+	}
+}
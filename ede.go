@@ -0,0 +1,46 @@
+package etcdhosts
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/miekg/dns"
+)
+
+// The Extended DNS Error option of RFC 8914 isn't known to the dns package yet, it is sent as a
+// local option with the EDE option code.
+const edeOption = 15
+
+// Extended DNS Error info codes of RFC 8914 section 4.
+const (
+	edeStaleAnswer          = 3
+	edeBlocked              = 15
+	edeFiltered             = 17
+	edeNotSupported         = 21
+	edeNoReachableAuthority = 22
+)
+
+// addEDE adds an Extended DNS Error to the reply m, nothing is added when m has no OPT record
+// because the query didn't have one.
+func addEDE(m *dns.Msg, code uint16, text string) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	data := make([]byte, 2, 2+len(text))
+	binary.BigEndian.PutUint16(data, code)
+	opt.Option = append(opt.Option, &dns.EDNS0_LOCAL{Code: edeOption, Data: append(data, text...)})
+}
+
+// addEtcdEDE tells in the reply m that it is made from stale or static data when etcd can't
+// be read.
+func (h Hosts) addEtcdEDE(m *dns.Msg) {
+	if !h.etcdDown() {
+		return
+	}
+	if atomic.LoadInt32(&h.etcdRead) == 0 {
+		addEDE(m, edeNoReachableAuthority, "etcd has not been read yet")
+		return
+	}
+	addEDE(m, edeStaleAnswer, "etcd can't be read, answered from the last hosts data")
+}
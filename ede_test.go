@@ -0,0 +1,51 @@
+package etcdhosts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSExtendedError(t *testing.T) {
+	tests := []struct {
+		name  string
+		qname string
+		qtype uint16
+		setup func(h Hosts)
+		code  uint16
+	}{
+		{"stale", "www.example.org.", dns.TypeA, func(h Hosts) { h.etcdFailures = 1 }, edeStaleAnswer},
+		{"not read", "www.example.org.", dns.TypeA, func(h Hosts) { h.etcdRead = 0 }, edeNoReachableAuthority},
+		{"filtered", "www.example.org.", dns.TypeTXT, func(h Hosts) {
+			h.options.disabledTypes = map[uint16]bool{dns.TypeTXT: true}
+		}, edeFiltered},
+		{"blocked", "ads.example.org.", dns.TypeA, func(h Hosts) {
+			h.options.denylist, h.options.denyRcode = []string{"ads.example.org."}, dns.RcodeRefused
+		}, edeBlocked},
+	}
+
+	for _, tc := range tests {
+		h := newTestHosts(hostsExample)
+		tc.setup(h)
+
+		m := new(dns.Msg)
+		m.SetQuestion(tc.qname, tc.qtype)
+		m.SetEdns0(4096, false)
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("%s: expected no error, got %s", tc.name, err)
+		}
+		if code, ok := edeCode(rec.Msg); !ok || code != tc.code {
+			t.Errorf("%s: expected the extended error %d, got %d", tc.name, tc.code, code)
+		}
+
+		// there is nowhere to put the error without EDNS0
+		if rec := serve(t, h, tc.qname, tc.qtype); rec.Msg.IsEdns0() != nil {
+			t.Errorf("%s: expected no OPT record, got %s", tc.name, rec.Msg)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package etcdhosts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// modifyHosts rewrites the hosts data stored in etcd with modify, the write only succeeds
+// if the key has not been modified since it was read. Nothing is written when modify reports
+// that it didn't change the data.
+func (h *Hostsfile) modifyHosts(ctx context.Context, modify func(data []byte) ([]byte, bool)) error {
+	ctx, cancel := context.WithTimeout(ctx, h.etcdTimeout)
+	defer cancel()
+
+	cli := h.client()
+	getResp, err := cli.Get(ctx, h.etcdHostsKey)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	var modRevision int64
+	if len(getResp.Kvs) > 0 {
+		data = getResp.Kvs[0].Value
+		modRevision = getResp.Kvs[0].ModRevision
+	}
+
+	newData, changed := modify(data)
+	if !changed {
+		return nil
+	}
+
+	txnResp, err := cli.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(h.etcdHostsKey), "=", modRevision)).
+		Then(clientv3.OpPut(h.etcdHostsKey, string(newData))).
+		Commit()
+	if err != nil {
+		return err
+	}
+	if !txnResp.Succeeded {
+		return fmt.Errorf("etcd key [%s] modified concurrently, please retry", h.etcdHostsKey)
+	}
+	return nil
+}
+
+// updateHosts replaces the addresses of the given family for name with ips.
+// It reports whether name had any addresses of the family.
+func updateHosts(data []byte, name string, family int, ips []net.IP) ([]byte, bool) {
+	return editHosts(data, name, family, func(net.IP) bool { return true }, ips)
+}
+
+// editHosts removes name from every line of the hosts data whose address is of the given
+// family and matches remove, then appends a line for each of add. Comments and unrelated
+// lines are kept as is. It reports whether name was removed from any line.
+func editHosts(data []byte, name string, family int, remove func(net.IP) bool, add []net.IP) ([]byte, bool) {
+	var buf bytes.Buffer
+	found := false
+
+	// The data is written back to etcd, so lines are split without the length limit of a
+	// bufio.Scanner that would drop everything after a long line.
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	for _, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		content, comment := line, ""
+		if i := strings.Index(line, "#"); i >= 0 {
+			content, comment = line[0:i], line[i:]
+		}
+
+		f := strings.Fields(content)
+		addr := net.IP(nil)
+		if len(f) >= 2 {
+			addr = parseIP(f[0])
+		}
+		if addr == nil || ipFamily(addr) != family || !remove(addr) {
+			buf.WriteString(line + "\n")
+			continue
+		}
+
+		names := f[:1]
+		for _, n := range f[1:] {
+			if normalizeName(n) == name {
+				found = true
+				continue
+			}
+			names = append(names, n)
+		}
+		if len(names) == len(f) {
+			buf.WriteString(line + "\n")
+			continue
+		}
+		if len(names) > 1 {
+			buf.WriteString(strings.Join(names, " "))
+			if comment != "" {
+				buf.WriteString(" " + comment)
+			}
+			buf.WriteString("\n")
+		}
+	}
+
+	for _, ip := range add {
+		buf.WriteString(ip.String() + " " + strings.TrimSuffix(name, ".") + "\n")
+	}
+	return buf.Bytes(), found
+}
+
+// nameInUse reports whether name has any address in the hosts data.
+func nameInUse(data []byte, name string) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		addr, names, err := parseLine([]byte(line))
+		if addr == nil || err != nil {
+			continue
+		}
+		for _, n := range names {
+			if normalizeName(string(n)) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package etcdhosts
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestModifyHosts(t *testing.T) {
+	cli, kv := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	h := newTestHostsfile(cli)
+
+	err := h.modifyHosts(context.Background(), func(data []byte) ([]byte, bool) {
+		return append(data, "10.0.0.3 new.example.org\n"...), true
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if value := string(kv.kvs[testHostsKey].Value); !strings.HasSuffix(value, "10.0.0.3 new.example.org\n") {
+		t.Errorf("expected the new entry to be written, got %q", value)
+	}
+}
+
+func TestModifyHostsConflict(t *testing.T) {
+	cli, kv := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	h := newTestHostsfile(cli)
+
+	const concurrent = "10.0.0.4 concurrent.example.org\n"
+	err := h.modifyHosts(context.Background(), func(data []byte) ([]byte, bool) {
+		// another writer changes the key between the read and the transaction
+		kv.Lock()
+		kv.put(testHostsKey, concurrent)
+		kv.Unlock()
+		return append(data, "10.0.0.3 new.example.org\n"...), true
+	})
+	if err == nil || !strings.Contains(err.Error(), "modified concurrently") {
+		t.Fatalf("expected a conflict error, got %v", err)
+	}
+	if value := string(kv.kvs[testHostsKey].Value); value != concurrent {
+		t.Errorf("expected the concurrent write to be kept, got %q", value)
+	}
+}
+
+func TestModifyHostsUnchanged(t *testing.T) {
+	cli, kv := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	h := newTestHostsfile(cli)
+
+	// deleting a name that doesn't exist changes nothing
+	err := h.modifyHosts(context.Background(), func(data []byte) ([]byte, bool) {
+		return updateHosts(data, "missing.example.org.", 1, nil)
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if version := kv.kvs[testHostsKey].Version; version != 1 {
+		t.Errorf("expected the key not to be written, got version %d", version)
+	}
+}
+
+func TestEditHostsLongLine(t *testing.T) {
+	long := "10.0.0.9 " + strings.Repeat("a", 70000) + ".example.org"
+	data := []byte("10.0.0.1 www.example.org\n" + long + "\n10.0.0.2 db.example.org\n")
+
+	newData, found := updateHosts(data, "www.example.org.", 1, []net.IP{net.ParseIP("10.0.0.3")})
+	if !found {
+		t.Errorf("expected www.example.org to be found")
+	}
+	expected := long + "\n10.0.0.2 db.example.org\n10.0.0.3 www.example.org\n"
+	if string(newData) != expected {
+		t.Errorf("expected %d bytes of hosts data, got %d", len(expected), len(newData))
+	}
+}
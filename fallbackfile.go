@@ -0,0 +1,57 @@
+package etcdhosts
+
+import (
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// readFallbackFile reads the A and AAAA records of the zone file of fallback_file into the static
+// entries, which are only answered while etcd can't be read. The file is only read again once it
+// changed, names of the zone file without $ORIGIN must be fully qualified.
+func (h *Hostsfile) readFallbackFile() error {
+	if h.fallbackFile == "" {
+		return nil
+	}
+
+	info, err := os.Stat(h.fallbackFile)
+	if err != nil {
+		return err
+	}
+	h.RLock()
+	modTime := h.fallbackModTime
+	h.RUnlock()
+	if info.ModTime().Equal(modTime) {
+		return nil
+	}
+
+	f, err := os.Open(h.fallbackFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// The records are turned into hosts lines, so they are parsed like the static entries.
+	lines := append([]string{}, h.staticLines...)
+	zp := dns.NewZoneParser(f, "", h.fallbackFile)
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		switch rr := rr.(type) {
+		case *dns.A:
+			lines = append(lines, rr.A.String()+" "+rr.Hdr.Name)
+		case *dns.AAAA:
+			lines = append(lines, rr.AAAA.String()+" "+rr.Hdr.Name)
+		}
+	}
+	if err := zp.Err(); err != nil {
+		return err
+	}
+	static := h.parse(strings.NewReader(strings.Join(lines, "\n")))
+	log.Infof("Read %d entries from fallback file %s", static.Len(), h.fallbackFile)
+
+	h.Lock()
+	h.static = static
+	h.fallbackModTime = info.ModTime()
+	h.Unlock()
+	return nil
+}
@@ -0,0 +1,51 @@
+package etcdhosts
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fallbackZone = `$ORIGIN example.org.
+www     3600 IN A     10.0.0.1
+www     3600 IN AAAA  fd00::1
+mail.example.org. IN A 10.0.0.2
+@            IN MX    10 mail
+`
+
+func TestReadFallbackFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "etcdhosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	h := newTestHostsfile(nil)
+	h.fallbackFile = filepath.Join(dir, "fallback.zone")
+	if err := ioutil.WriteFile(h.fallbackFile, []byte(fallbackZone), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.readFallbackFile(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	// etcd has not been read, so the fallback file is answered
+	if ips := h.LookupStaticHostV4("www.example.org."); len(ips) != 1 || ips[0].String() != "10.0.0.1" {
+		t.Errorf("expected 10.0.0.1, got %v", ips)
+	}
+	if ips := h.LookupStaticHostV6("www.example.org."); len(ips) != 1 || ips[0].String() != "fd00::1" {
+		t.Errorf("expected fd00::1, got %v", ips)
+	}
+	if ips := h.LookupStaticHostV4("mail.example.org."); len(ips) != 1 {
+		t.Errorf("expected 1 address, got %v", ips)
+	}
+
+	if err := ioutil.WriteFile(h.fallbackFile, []byte("www IN A 10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h.fallbackModTime = h.fallbackModTime.Add(-1)
+	if err := h.readFallbackFile(); err == nil {
+		t.Errorf("expected an error for names that are not fully qualified")
+	}
+}
@@ -8,4 +8,7 @@ require (
 	github.com/miekg/dns v1.1.34
 	github.com/prometheus/client_golang v1.8.0
 	go.etcd.io/etcd v0.5.0-alpha.5.0.20200306183522-221f0cc107cb
+	go.uber.org/goleak v1.1.10
+	golang.org/x/net v0.0.0-20200707034311-ab3426394381
+	google.golang.org/grpc v1.29.1
 )
@@ -0,0 +1,73 @@
+package etcdhosts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+)
+
+// readHealth reads the unhealthy addresses from the health key, one address per line. A missing
+// key means all addresses are healthy.
+func (h *Hostsfile) readHealth() {
+	if h.etcdHealthKey == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.etcdTimeout)
+	defer cancel()
+	getResp, err := h.client().Get(ctx, h.etcdHealthKey)
+	if err != nil {
+		log.Errorf("failed to get etcd health key [%s]: %s", h.etcdHealthKey, err.Error())
+		return
+	}
+
+	unhealthy := make(map[string]bool)
+	if len(getResp.Kvs) > 0 {
+		scanner := bufio.NewScanner(bytes.NewReader(getResp.Kvs[0].Value))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			ip := parseIP(line)
+			if ip == nil {
+				log.Warningf("invalid address '%s' in etcd health key [%s]", line, h.etcdHealthKey)
+				continue
+			}
+			unhealthy[ip.String()] = true
+		}
+	}
+	log.Debugf("Read %d unhealthy addresses", len(unhealthy))
+
+	h.Lock()
+	h.unhealthy = unhealthy
+	h.Unlock()
+}
+
+// healthy returns the addresses of ips that are not marked unhealthy. All addresses are returned
+// when none of them is healthy, answering an unhealthy address is better than no answer.
+func (h *Hostsfile) healthy(ips []net.IP) []net.IP {
+	h.RLock()
+	unhealthy := h.unhealthy
+	h.RUnlock()
+	if len(unhealthy) == 0 {
+		return ips
+	}
+
+	var out []net.IP
+	for _, ip := range ips {
+		if !unhealthy[ip.String()] {
+			out = append(out, ip)
+		}
+	}
+	if len(out) == 0 {
+		return ips
+	}
+	return out
+}
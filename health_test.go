@@ -0,0 +1,39 @@
+package etcdhosts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSUnhealthy(t *testing.T) {
+	tests := []struct {
+		health string
+		ips    []string
+	}{
+		{"", []string{"10.0.0.1", "10.0.0.2"}},
+		{"10.0.0.1 # maintenance\n", []string{"10.0.0.2"}},
+		// all addresses are answered when none of them is healthy
+		{"10.0.0.1\n10.0.0.2\n", []string{"10.0.0.1", "10.0.0.2"}},
+	}
+
+	for _, tc := range tests {
+		cli, _ := newFakeClient(map[string]string{testHostsKey: hostsExample, "/etcdhosts-health": tc.health})
+		h := newTestHosts(hostsExample)
+		h.etcdClient = cli
+		h.etcdHealthKey = "/etcdhosts-health"
+		h.etcdTimeout = time.Second
+		h.readHealth()
+
+		rec := serve(t, h, "www.example.org.", dns.TypeA)
+		var ips []string
+		for _, rr := range rec.Msg.Answer {
+			ips = append(ips, rr.(*dns.A).A.String())
+		}
+		if !reflect.DeepEqual(ips, tc.ips) {
+			t.Errorf("%q: expected the addresses %v, got %v", tc.health, tc.ips, ips)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package etcdhosts
 import (
 	"context"
 	"net"
+	"strings"
+	"sync/atomic"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/coredns/coredns/plugin/pkg/dnsutil"
@@ -18,6 +20,12 @@ type Hosts struct {
 	*Hostsfile
 
 	Fall fall.F
+
+	// limits the queries answered per client ip, nil if unlimited
+	limiter *rateLimiter
+
+	// signs answers for DNSSEC aware clients, nil if disabled
+	dnssec *dnssec
 }
 
 // ServeDNS implements the plugin.Handle interface.
@@ -27,6 +35,36 @@ func (h Hosts) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 
 	var answers []dns.RR
 
+	if (h.options.chaosVersion != "" || h.options.chaosDebug) && state.QClass() == dns.ClassCHAOS {
+		// Debug queries reach etcd, they are limited like the other queries.
+		if rcode, limited := h.limit(state); limited {
+			return rcode, nil
+		}
+		if answers = h.chaos(ctx, state); len(answers) > 0 {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Authoritative = true
+			m.Answer = answers
+			h.writeMsg(state, m)
+			return dns.RcodeSuccess, nil
+		}
+	}
+
+	if r.Opcode == dns.OpcodeUpdate {
+		if len(h.options.updateNets) == 0 {
+			return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, h.serveUpdate(ctx, state))
+		h.writeMsg(state, m)
+		return dns.RcodeSuccess, nil
+	}
+
+	// The hosts data only holds class IN records, other classes are left to the next plugin.
+	if state.QClass() != dns.ClassINET {
+		return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+	}
+
 	zone := plugin.Zones(h.Origins).Matches(qname)
 	if zone == "" {
 		// PTR zones don't need to be specified in Origins.
@@ -36,6 +74,63 @@ func (h Hosts) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 		}
 	}
 
+	if !validQueryName(qname) {
+		log.Debugf("refusing malformed query name %q", qname)
+		m := new(dns.Msg)
+		m.SetRcode(r, dns.RcodeFormatError)
+		h.writeMsg(state, m)
+		return dns.RcodeSuccess, nil
+	}
+
+	if len(h.options.denylist) > 0 && plugin.Zones(h.options.denylist).Matches(qname) != "" {
+		m := new(dns.Msg)
+		m.SetRcode(r, h.options.denyRcode)
+		state.SizeAndDo(m)
+		addEDE(m, edeBlocked, "name is denylisted")
+		h.writeMsg(state, m)
+		// The reply is written, REFUSED or SERVFAIL must not make the server write another one.
+		return dns.RcodeSuccess, nil
+	}
+
+	if rcode, limited := h.limit(state); limited {
+		return rcode, nil
+	}
+
+	if h.options.disabledTypes[state.QType()] || !h.typeAllowed(qname, state.QType()) {
+		if zone == "" {
+			return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+		}
+		// Disabled types get a NODATA response whatever the hosts data holds.
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Authoritative = true
+		m.Ns = h.soa(zone, h.options.ttlOf(dns.TypeSOA))
+		state.SizeAndDo(m)
+		addEDE(m, edeFiltered, "query type is not answered in this zone")
+		h.writeMsg(state, m)
+		return dns.RcodeSuccess, nil
+	}
+
+	// Names in the hosts data get a NODATA response for the types they can't have, e.g. MX at the
+	// apex, so mail servers fall back to the address records as in RFC 5321 section 5.1.
+	if !h.supported(state.QType()) && (zone == "" || !h.NameExists(qname)) {
+		var rcode int
+		switch h.options.unsupported {
+		case "notimp":
+			rcode = dns.RcodeNotImplemented
+		case "refused":
+			rcode = dns.RcodeRefused
+		default:
+			return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
+		}
+		m := new(dns.Msg)
+		m.SetRcode(r, rcode)
+		state.SizeAndDo(m)
+		addEDE(m, edeNotSupported, "query type is not supported")
+		h.writeMsg(state, m)
+		return dns.RcodeSuccess, nil
+	}
+
 	switch state.QType() {
 	case dns.TypePTR:
 		names := h.LookupStaticAddr(dnsutil.ExtractAddressFromReverse(qname))
@@ -43,47 +138,227 @@ func (h Hosts) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (
 			// If this doesn't match we need to fall through regardless of h.Fallthrough
 			return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
 		}
-		answers = h.ptr(qname, h.options.ttl, names)
+		answers = h.ptr(qname, h.options.ttlOf(dns.TypePTR), names)
 	case dns.TypeA:
 		ips := h.LookupStaticHostV4(qname)
-		answers = a(qname, h.options.ttl, ips)
+		ips = h.healthy(ips)
+		ips = orderIPs(h.options.sortlist, h.options.loadbalance, net.ParseIP(state.IP()), ips)
+		answers = a(qname, h.options.ttlOf(dns.TypeA), ips)
 	case dns.TypeAAAA:
 		ips := h.LookupStaticHostV6(qname)
-		answers = aaaa(qname, h.options.ttl, ips)
+		if len(ips) == 0 && h.options.dns64 != nil {
+			ips = synthAAAA(h.options.dns64, h.healthy(h.LookupStaticHostV4(qname)))
+		}
+		ips = h.healthy(ips)
+		ips = orderIPs(h.options.sortlist, h.options.loadbalance, net.ParseIP(state.IP()), ips)
+		answers = aaaa(qname, h.options.ttlOf(dns.TypeAAAA), ips)
+	case dns.TypeANY:
+		if h.options.minimalAny && h.NameExists(qname) {
+			answers = hinfo(qname, h.options.ttlOf(dns.TypeHINFO))
+		}
+	case dns.TypeSOA:
+		if qname == zone {
+			answers = h.soa(zone, h.options.ttlOf(dns.TypeSOA))
+		}
+	case dns.TypeNS:
+		if qname == zone {
+			answers = h.ns(zone, h.options.ttlOf(dns.TypeNS))
+		}
+	case dns.TypeDNSKEY:
+		if h.dnssec != nil {
+			answers = h.dnssec.dnskey(qname, h.options.ttlOf(dns.TypeDNSKEY))
+		}
+	}
+
+	if max := h.options.maxAnswers; max > 0 && len(answers) > max {
+		log.Warningf("%s %s has %d records, only the first %d are answered", qname, state.Type(), len(answers), max)
+		answers = answers[:max]
 	}
 
 	if len(answers) == 0 {
 		if h.Fall.Through(qname) {
 			return plugin.NextOrFailure(h.Name(), h.Next, ctx, w, r)
 		}
-		// We want to send an NXDOMAIN, but because of /etc/hosts' setup we don't have a SOA, so we make it REFUSED
-		// to at least give an answer back to signals we're having problems resolving this.
-		if !h.otherRecordsExist(qname) {
-			return dns.RcodeServerFailure, nil
+		// The name doesn't exist at all, otherwise this is a NODATA response with an empty answer.
+		// The apex always exists, it has the SOA. Signed answers deny the name with a NSEC black
+		// lie in the NODATA response instead.
+		if qname != zone && !h.NameExists(qname) && (h.dnssec == nil || !state.Do() || zone == "") {
+			m := new(dns.Msg)
+			m.SetRcode(r, dns.RcodeNameError)
+			m.Authoritative = zone != ""
+			if zone != "" {
+				m.Ns = h.soa(zone, h.options.ttlOf(dns.TypeSOA))
+			}
+			state.SizeAndDo(m)
+			h.addEtcdEDE(m)
+			h.writeMsg(state, m)
+			return dns.RcodeNameError, nil
 		}
 	}
 
 	m := new(dns.Msg)
 	m.SetReply(r)
-	m.Authoritative = true
+	// We are only authoritative for our Origins, PTR answers for other reverse zones are not.
+	m.Authoritative = zone != ""
 	m.Answer = answers
+	if len(answers) == 0 && zone != "" {
+		// The SOA in the authority section lets resolvers cache the NODATA response (RFC 2308).
+		m.Ns = h.soa(zone, h.options.ttlOf(dns.TypeSOA))
+	} else if zone != "" && state.QType() != dns.TypeNS && !h.options.minimal {
+		m.Ns = h.ns(zone, h.options.ttlOf(dns.TypeNS))
+	}
+	// The addresses of the nameservers save resolvers another query.
+	if !h.options.minimal {
+		m.Extra = h.glue(m.Answer, m.Ns)
+	}
+	if h.dnssec != nil && state.Do() {
+		if len(answers) == 0 && zone != "" {
+			m.Ns = append(m.Ns, blackLie(qname, h.options.ttlOf(dns.TypeSOA), h.nsecTypes(qname, zone, state.QType())))
+		}
+		m.Answer = h.dnssec.sign(m.Answer)
+		m.Ns = h.dnssec.sign(m.Ns)
+		m.Extra = h.dnssec.sign(m.Extra)
+	}
 
-	_ = w.WriteMsg(m)
+	// Echo the client's EDNS0 OPT record and trim the answer to its advertised buffer size,
+	// this sets TC so the client retries over TCP.
+	state.SizeAndDo(m)
+	h.addEtcdEDE(m)
+	m.Truncate(state.Size())
+
+	h.writeMsg(state, m)
 	return dns.RcodeSuccess, nil
 }
 
-func (h Hosts) otherRecordsExist(qname string) bool {
+// glue returns the A and AAAA records of the nameservers of the NS records in sections, nameservers
+// outside of Origins are skipped. At most maxGlue records are returned.
+func (h Hosts) glue(sections ...[]dns.RR) []dns.RR {
+	var rrs []dns.RR
+	for _, s := range sections {
+		rrs = append(rrs, s...)
+	}
+
+	var extra []dns.RR
+	seen := make(map[string]bool)
+	for _, rr := range rrs {
+		ns, ok := rr.(*dns.NS)
+		if !ok || seen[ns.Ns] || plugin.Zones(h.Origins).Matches(ns.Ns) == "" {
+			continue
+		}
+		seen[ns.Ns] = true
+		extra = append(extra, a(ns.Ns, h.options.ttlOf(dns.TypeA), h.healthy(h.LookupStaticHostV4(ns.Ns)))...)
+		extra = append(extra, aaaa(ns.Ns, h.options.ttlOf(dns.TypeAAAA), h.healthy(h.LookupStaticHostV6(ns.Ns)))...)
+	}
+	if len(extra) > maxGlue {
+		extra = extra[:maxGlue]
+	}
+	return extra
+}
+
+// nsecTypes returns the types of the records at qname for the NSEC record that denies qtype.
+func (h Hosts) nsecTypes(qname, zone string, qtype uint16) []uint16 {
+	var types []uint16
 	if len(h.LookupStaticHostV4(qname)) > 0 {
-		return true
+		types = append(types, dns.TypeA)
+	}
+	if qname == zone {
+		if len(h.options.nameservers) > 0 {
+			types = append(types, dns.TypeNS)
+		}
+		types = append(types, dns.TypeSOA)
 	}
 	if len(h.LookupStaticHostV6(qname)) > 0 {
+		types = append(types, dns.TypeAAAA)
+	}
+	types = append(types, dns.TypeRRSIG, dns.TypeNSEC)
+	if h.dnssec.dnskey(qname, 0) != nil {
+		types = append(types, dns.TypeDNSKEY)
+	}
+
+	// The type bitmap must not claim the denied type, e.g. addresses that are all unhealthy.
+	filtered := types[:0]
+	for _, t := range types {
+		if t != qtype {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// limit takes a token from the bucket of the client and reports whether the query is over the
+// rate limit, the query is then answered with rcode.
+func (h Hosts) limit(state request.Request) (int, bool) {
+	if h.limiter == nil || h.limiter.allow(state.IP()) {
+		return 0, false
+	}
+
+	rateLimitedCount.WithLabelValues().Inc()
+	if state.Proto() == "tcp" {
+		return dns.RcodeRefused, true
+	}
+	// Answer with an empty truncated reply, so a real client can still retry over TCP
+	// while a spoofed source doesn't get anything to amplify.
+	m := new(dns.Msg)
+	m.SetReply(state.Req)
+	m.Truncated = true
+	h.writeMsg(state, m)
+	return dns.RcodeSuccess, true
+}
+
+// typeAllowed reports whether qtype may be answered for qname, the most specific zones of
+// allow_types and deny_types that contain qname decide.
+func (h Hosts) typeAllowed(qname string, qtype uint16) bool {
+	if types := zoneTypes(h.options.allowedTypes, qname); types != nil && !types[qtype] {
+		return false
+	}
+	if types := zoneTypes(h.options.deniedTypes, qname); types != nil && types[qtype] {
+		return false
+	}
+	return true
+}
+
+// zoneTypes returns the types of the most specific zone of m that contains qname.
+func zoneTypes(m map[string]map[uint16]bool, qname string) map[uint16]bool {
+	var zone string
+	for z := range m {
+		if dns.IsSubDomain(z, qname) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	if zone == "" {
+		return nil
+	}
+	return m[zone]
+}
+
+// supported reports whether answers of type qtype can be made from the hosts data.
+func (h Hosts) supported(qtype uint16) bool {
+	switch qtype {
+	case dns.TypeA, dns.TypeAAAA, dns.TypePTR, dns.TypeANY, dns.TypeSOA:
 		return true
+	case dns.TypeNS:
+		return len(h.options.nameservers) > 0
+	case dns.TypeDNSKEY:
+		return h.dnssec != nil
 	}
 	return false
 }
 
+// validQueryName checks the length of the name and its labels. Escaped bytes, e.g. \000, never
+// match a host name, so names containing them are refused as well.
+func validQueryName(name string) bool {
+	if _, ok := dns.IsDomainName(name); !ok {
+		return false
+	}
+	return !strings.Contains(name, "\\")
+}
+
 // Name implements the plugin.Handle interface.
-func (h Hosts) Name() string { return "etcdhosts" }
+func (h Hosts) Name() string { return pluginName }
+
+// Ready implements the ready.Readiness interface, the plugin is ready once the hosts data has
+// been read from etcd.
+func (h Hosts) Ready() bool { return atomic.LoadInt32(&h.etcdRead) == 1 }
 
 // a takes a slice of net.IPs and returns a slice of A RRs.
 func a(zone string, ttl uint32, ips []net.IP) []dns.RR {
@@ -109,6 +384,14 @@ func aaaa(zone string, ttl uint32, ips []net.IP) []dns.RR {
 	return answers
 }
 
+// hinfo returns the synthesized HINFO RR of RFC 8482 that answers ANY queries.
+func hinfo(zone string, ttl uint32) []dns.RR {
+	r := new(dns.HINFO)
+	r.Hdr = dns.RR_Header{Name: zone, Rrtype: dns.TypeHINFO, Class: dns.ClassINET, Ttl: ttl}
+	r.Cpu = "RFC8482"
+	return []dns.RR{r}
+}
+
 // ptr takes a slice of host names and filters out the ones that aren't in Origins, if specified, and returns a slice of PTR RRs.
 func (h *Hosts) ptr(zone string, ttl uint32, names []string) []dns.RR {
 	answers := make([]dns.RR, len(names))
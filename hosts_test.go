@@ -0,0 +1,424 @@
+package etcdhosts
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/pkg/fall"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+const hostsExample = `
+127.0.0.1 localhost
+10.0.0.1 example.org www.example.org
+10.0.0.2 www.example.org
+::1 localhost
+fd00::1 www.example.org
+`
+
+// newTestHosts returns a Hosts for example.org that answers from data as if it was read from etcd.
+func newTestHosts(data string) Hosts {
+	h := Hosts{
+		Next: test.NextHandler(dns.RcodeSuccess, nil),
+		Hostsfile: &Hostsfile{
+			Origins:  []string{"example.org."},
+			hmap:     newMap(),
+			inline:   newMap(),
+			static:   newMap(),
+			etcdRead: 1,
+			options:  newOptions(),
+		},
+	}
+	h.options.unsupported = "fallthrough"
+	h.options.loadbalance = "none"
+	h.hmap = h.parse(strings.NewReader(data))
+	return h
+}
+
+// serve sends a query for name and qtype to h and returns the recorded reply and rcode.
+func serve(t *testing.T, h Hosts, name string, qtype uint16) *dnstest.Recorder {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	rcode, err := h.ServeDNS(context.Background(), rec, m)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	rec.Rcode = rcode
+	return rec
+}
+
+// edeCode returns the info code of the Extended DNS Error in m.
+func edeCode(m *dns.Msg) (uint16, bool) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return 0, false
+	}
+	for _, o := range opt.Option {
+		if o, ok := o.(*dns.EDNS0_LOCAL); ok && o.Code == edeOption && len(o.Data) >= 2 {
+			return binary.BigEndian.Uint16(o.Data), true
+		}
+	}
+	return 0, false
+}
+
+func TestServeDNSUnsupported(t *testing.T) {
+	tests := []struct {
+		mode  string
+		rcode int
+		// the reply is written by etcdhosts, not by the next plugin
+		written bool
+	}{
+		{"fallthrough", dns.RcodeSuccess, false},
+		{"notimp", dns.RcodeNotImplemented, true},
+		{"refused", dns.RcodeRefused, true},
+	}
+
+	for _, tc := range tests {
+		h := newTestHosts(hostsExample)
+		h.options.unsupported = tc.mode
+
+		rec := serve(t, h, "missing.example.org", dns.TypeMX)
+		if rec.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: expected the returned rcode to be NOERROR, got %s", tc.mode, dns.RcodeToString[rec.Rcode])
+		}
+		if !tc.written {
+			if rec.Msg != nil {
+				t.Errorf("%s: expected the query to go to the next plugin, got %s", tc.mode, rec.Msg)
+			}
+			continue
+		}
+		if rec.Msg == nil {
+			t.Fatalf("%s: expected a reply", tc.mode)
+		}
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("%s: expected rcode %s, got %s", tc.mode, dns.RcodeToString[tc.rcode], dns.RcodeToString[rec.Msg.Rcode])
+		}
+	}
+}
+
+func TestValidQueryName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"example.org.", true},
+		{"www.example.org.", true},
+		{"_http._tcp.example.org.", true},
+		{"ex\\000ample.org.", false},
+		{"ex\\.ample.org.", false},
+		{strings.Repeat("a", 64) + ".example.org.", false},
+	}
+
+	for _, tc := range tests {
+		if got := validQueryName(tc.name); got != tc.valid {
+			t.Errorf("%s: expected valid %t, got %t", tc.name, tc.valid, got)
+		}
+	}
+}
+
+func TestTypeAllowed(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.options.allowedTypes = map[string]map[uint16]bool{
+		"example.org.": {dns.TypeA: true, dns.TypeAAAA: true, dns.TypeTXT: true},
+	}
+	h.options.deniedTypes = map[string]map[uint16]bool{
+		"internal.example.org.": {dns.TypeAAAA: true},
+	}
+
+	tests := []struct {
+		qname   string
+		qtype   uint16
+		allowed bool
+	}{
+		{"www.example.org.", dns.TypeA, true},
+		{"www.example.org.", dns.TypeAAAA, true},
+		{"www.example.org.", dns.TypeMX, false},
+		{"www.internal.example.org.", dns.TypeA, true},
+		{"www.internal.example.org.", dns.TypeAAAA, false},
+		{"example.net.", dns.TypeMX, true},
+	}
+
+	for _, tc := range tests {
+		if got := h.typeAllowed(tc.qname, tc.qtype); got != tc.allowed {
+			t.Errorf("%s %s: expected allowed %t, got %t", tc.qname, dns.TypeToString[tc.qtype], tc.allowed, got)
+		}
+	}
+}
+
+func TestServeDNSNameError(t *testing.T) {
+	tests := []struct {
+		name  string
+		qtype uint16
+		rcode int
+	}{
+		// example.org only has an IPv4 address
+		{"example.org.", dns.TypeAAAA, dns.RcodeSuccess},
+		{"www.example.org.", dns.TypeMX, dns.RcodeSuccess},
+		// mail servers fall back to the address of the apex
+		{"example.org.", dns.TypeMX, dns.RcodeSuccess},
+		// an empty non-terminal exists
+		{"internal.example.org.", dns.TypeA, dns.RcodeSuccess},
+		{"missing.example.org.", dns.TypeA, dns.RcodeNameError},
+		{"missing.example.org.", dns.TypeAAAA, dns.RcodeNameError},
+	}
+
+	h := newTestHosts(hostsExample + "10.0.0.5 db.internal.example.org\n")
+	// the apex exists without hosts entries
+	rec := serve(t, newTestHosts(""), "example.org.", dns.TypeA)
+	if rec.Msg == nil || rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Ns) != 1 {
+		t.Errorf("expected a NODATA reply at the apex, got %v", rec.Msg)
+	}
+
+	for _, tc := range tests {
+		rec := serve(t, h, tc.name, tc.qtype)
+		if rec.Msg == nil {
+			t.Fatalf("%s %s: expected a reply", tc.name, dns.TypeToString[tc.qtype])
+		}
+		if rec.Msg.Rcode != tc.rcode {
+			t.Errorf("%s %s: expected rcode %s, got %s", tc.name, dns.TypeToString[tc.qtype],
+				dns.RcodeToString[tc.rcode], dns.RcodeToString[rec.Msg.Rcode])
+		}
+		if len(rec.Msg.Answer) != 0 {
+			t.Errorf("%s %s: expected no answers, got %v", tc.name, dns.TypeToString[tc.qtype], rec.Msg.Answer)
+		}
+		if len(rec.Msg.Ns) != 1 || rec.Msg.Ns[0].Header().Rrtype != dns.TypeSOA {
+			t.Errorf("%s %s: expected the SOA record in the authority section, got %v", tc.name, dns.TypeToString[tc.qtype], rec.Msg.Ns)
+		}
+	}
+}
+
+func TestServeDNSInternationalName(t *testing.T) {
+	h := newTestHosts("")
+	h.Origins = []string{normalizeName("BÜCHER.example")}
+	h.hmap = h.parse(strings.NewReader("10.0.0.1 WWW.Bücher.example\n"))
+
+	rec := serve(t, h, "www.xn--bcher-kva.example.", dns.TypeA)
+	if rec.Msg == nil || len(rec.Msg.Answer) != 1 {
+		t.Fatalf("expected 1 answer, got %v", rec.Msg)
+	}
+}
+
+func TestServeDNSReverseIPv6(t *testing.T) {
+	h := newTestHosts(hostsExample + "FD00:0000::0002 db.example.org\n")
+
+	tests := []struct {
+		qname    string
+		expected []string
+	}{
+		{"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", []string{"www.example.org."}},
+		// stored addresses are keyed by their canonical form
+		{"2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.D.F.ip6.arpa.", []string{"db.example.org."}},
+		{"1.0.0.10.in-addr.arpa.", []string{"example.org.", "www.example.org."}},
+		// not a full nibble address
+		{"1.0.0.0.d.f.ip6.arpa.", nil},
+		{"3.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.d.f.ip6.arpa.", nil},
+	}
+
+	for _, tc := range tests {
+		rec := serve(t, h, tc.qname, dns.TypePTR)
+		if tc.expected == nil {
+			if rec.Msg != nil {
+				t.Errorf("%s: expected the query to go to the next plugin, got %s", tc.qname, rec.Msg)
+			}
+			continue
+		}
+		if rec.Msg == nil || len(rec.Msg.Answer) != len(tc.expected) {
+			t.Fatalf("%s: expected %v, got %v", tc.qname, tc.expected, rec.Msg)
+		}
+		for i, rr := range rec.Msg.Answer {
+			if ptr := rr.(*dns.PTR).Ptr; ptr != tc.expected[i] {
+				t.Errorf("%s: expected %v, got %s", tc.qname, tc.expected, ptr)
+			}
+		}
+	}
+}
+
+func TestServeDNSTruncate(t *testing.T) {
+	var data strings.Builder
+	for i := 1; i <= 100; i++ {
+		fmt.Fprintf(&data, "10.0.1.%d big.example.org\n", i)
+	}
+	h := newTestHosts(data.String())
+
+	tests := []struct {
+		bufsize   uint16
+		truncated bool
+	}{
+		// without EDNS0 the reply is limited to 512 bytes
+		{0, true},
+		{4096, false},
+	}
+
+	for _, tc := range tests {
+		m := new(dns.Msg)
+		m.SetQuestion("big.example.org.", dns.TypeA)
+		if tc.bufsize > 0 {
+			m.SetEdns0(tc.bufsize, false)
+		}
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if rec.Msg.Truncated != tc.truncated {
+			t.Errorf("bufsize %d: expected TC %t, got %t", tc.bufsize, tc.truncated, rec.Msg.Truncated)
+		}
+		size := 512
+		if tc.bufsize > 0 {
+			size = int(tc.bufsize)
+		}
+		if rec.Msg.Len() > size {
+			t.Errorf("bufsize %d: expected the reply to fit, got %d bytes", tc.bufsize, rec.Msg.Len())
+		}
+		if tc.truncated && len(rec.Msg.Answer) == 100 {
+			t.Errorf("bufsize %d: expected the answer to be truncated", tc.bufsize)
+		}
+	}
+}
+
+func TestServeDNSGlue(t *testing.T) {
+	h := newTestHosts(hostsExample + "10.0.0.53 ns1.example.org\nfd00::53 ns1.example.org\n")
+	// the addresses of nameservers outside of the zones are not known
+	h.options.nameservers = []string{"ns1.example.org.", "ns.example.net."}
+
+	for _, qtype := range []uint16{dns.TypeNS, dns.TypeA} {
+		rec := serve(t, h, "example.org", qtype)
+		if len(rec.Msg.Extra) != 2 {
+			t.Errorf("%s: expected the A and AAAA of ns1.example.org, got %v", dns.TypeToString[qtype], rec.Msg.Extra)
+			continue
+		}
+		for _, rr := range rec.Msg.Extra {
+			if rr.Header().Name != "ns1.example.org." {
+				t.Errorf("%s: expected glue for ns1.example.org, got %s", dns.TypeToString[qtype], rr)
+			}
+		}
+	}
+}
+
+func TestServeDNSMinimal(t *testing.T) {
+	tests := []struct {
+		minimal bool
+		qname   string
+		ns      int
+		extra   int
+	}{
+		{false, "www.example.org", 1, 1},
+		{true, "www.example.org", 0, 0},
+		// the SOA of NODATA replies is kept, the apex has no AAAA
+		{true, "example.org", 1, 0},
+	}
+
+	for _, tc := range tests {
+		h := newTestHosts(hostsExample + "10.0.0.53 ns1.example.org\n")
+		h.options.nameservers = []string{"ns1.example.org."}
+		h.options.minimal = tc.minimal
+
+		rec := serve(t, h, tc.qname, dns.TypeAAAA)
+		if len(rec.Msg.Ns) != tc.ns || len(rec.Msg.Extra) != tc.extra {
+			t.Errorf("%s minimal %t: expected %d authority and %d additional records, got %v and %v",
+				tc.qname, tc.minimal, tc.ns, tc.extra, rec.Msg.Ns, rec.Msg.Extra)
+		}
+	}
+}
+
+func TestServeDNSAuthoritative(t *testing.T) {
+	tests := []struct {
+		name  string
+		qtype uint16
+		aa    bool
+	}{
+		{"www.example.org.", dns.TypeA, true},
+		// reverse zones that aren't in the zones are answered without AA
+		{"1.0.0.10.in-addr.arpa.", dns.TypePTR, false},
+	}
+
+	h := newTestHosts(hostsExample)
+	for _, tc := range tests {
+		rec := serve(t, h, tc.name, tc.qtype)
+		if rec.Msg == nil || len(rec.Msg.Answer) == 0 {
+			t.Fatalf("%s %s: expected an answer, got %v", tc.name, dns.TypeToString[tc.qtype], rec.Msg)
+		}
+		if rec.Msg.Authoritative != tc.aa {
+			t.Errorf("%s %s: expected AA to be %t", tc.name, dns.TypeToString[tc.qtype], tc.aa)
+		}
+	}
+}
+
+func TestServeDNSFallthroughAuthoritative(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.Fall = fall.Root
+	// the next plugin answers the names etcdhosts doesn't know
+	h.Next = plugin.HandlerFunc(func(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+		return dns.RcodeSuccess, nil
+	})
+
+	if rec := serve(t, h, "www.example.org.", dns.TypeA); !rec.Msg.Authoritative {
+		t.Errorf("expected AA on the answer from the hosts data")
+	}
+	if rec := serve(t, h, "missing.example.org.", dns.TypeA); rec.Msg.Authoritative {
+		t.Errorf("expected no AA on the reply of the next plugin")
+	}
+}
+
+func TestServeDNSDisabledTypes(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.options.disabledTypes = map[uint16]bool{dns.TypeTXT: true}
+
+	if rec := serve(t, h, "www.example.org.", dns.TypeA); len(rec.Msg.Answer) != 2 {
+		t.Errorf("expected the A records, got %v", rec.Msg.Answer)
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("www.example.org.", dns.TypeTXT)
+	m.SetEdns0(4096, false)
+	rec := dnstest.NewRecorder(&test.ResponseWriter{})
+	if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if rec.Msg.Rcode != dns.RcodeSuccess || len(rec.Msg.Answer) != 0 || len(rec.Msg.Ns) != 1 {
+		t.Errorf("expected a NODATA reply, got %s", rec.Msg)
+	}
+	if code, ok := edeCode(rec.Msg); !ok || code != edeFiltered {
+		t.Errorf("expected the Filtered extended error, got %d", code)
+	}
+}
+
+func TestServeDNSDenylist(t *testing.T) {
+	h := newTestHosts(hostsExample + "10.0.0.9 ads.example.org tracker.ads.example.org\n")
+	h.options.denylist, h.options.denyRcode = []string{"ads.example.org."}, dns.RcodeRefused
+
+	tests := []struct {
+		name    string
+		rcode   int
+		answers int
+	}{
+		{"ads.example.org.", dns.RcodeRefused, 0},
+		// names below a denylisted name are denied too
+		{"tracker.ads.example.org.", dns.RcodeRefused, 0},
+		{"www.example.org.", dns.RcodeSuccess, 2},
+	}
+
+	for _, tc := range tests {
+		rec := serve(t, h, tc.name, dns.TypeA)
+		if rec.Msg.Rcode != tc.rcode || len(rec.Msg.Answer) != tc.answers {
+			t.Errorf("%s: expected rcode %s with %d answers, got %s", tc.name, dns.RcodeToString[tc.rcode], tc.answers, rec.Msg)
+		}
+		// the reply is written, the server must not write another one
+		if rec.Rcode != dns.RcodeSuccess {
+			t.Errorf("%s: expected the returned rcode to be NOERROR, got %s", tc.name, dns.RcodeToString[rec.Rcode])
+		}
+	}
+}
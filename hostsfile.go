@@ -11,15 +11,22 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"fmt"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.etcd.io/etcd/clientv3"
+	"golang.org/x/net/idna"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/coredns/coredns/plugin"
+
+	"github.com/miekg/dns"
 )
 
 // parseIP calls discards any v6 zone info, before calling net.ParseIP.
@@ -32,6 +39,23 @@ func parseIP(addr string) net.IP {
 	return net.ParseIP(addr)
 }
 
+// normalizeName returns the lowercased FQDN of a host name, international names are mapped
+// and converted to their punycode form as resolvers do, so they match the queries.
+func normalizeName(name string) string {
+	if ascii, err := idna.Lookup.ToASCII(name); err == nil {
+		name = ascii
+	}
+	return plugin.Name(name).Normalize()
+}
+
+// ipFamily returns 1 for IPv4 and 2 for IPv6 addresses.
+func ipFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return 1
+	}
+	return 2
+}
+
 type options struct {
 	// automatically generate IP to Hostname PTR entries
 	// for host entries we parse
@@ -39,6 +63,67 @@ type options struct {
 
 	// The TTL of the record we generate
 	ttl uint32
+
+	// TTLs of the records of a type that replace ttl
+	ttlOverride map[uint16]uint32
+
+	// version returned for CH class version.bind queries,
+	// CH class queries are not answered when empty
+	chaosVersion string
+
+	// answer CH class version.etcdhosts queries with the plugin and etcd status
+	chaosDebug bool
+
+	// names that are answered with denyRcode, names below them included
+	denylist  []string
+	denyRcode int
+
+	// query types that are never answered
+	disabledTypes map[uint16]bool
+
+	// the only query types answered for names in a zone, keyed by zone
+	allowedTypes map[string]map[uint16]bool
+
+	// query types that are never answered for names in a zone, keyed by zone
+	deniedTypes map[string]map[uint16]bool
+
+	// answer to query types the hosts data can't hold: fallthrough, notimp or refused
+	unsupported string
+
+	// NAT64 prefix AAAA answers are synthesized in for names with only A records, disabled if nil
+	dns64 *net.IPNet
+
+	// SOA serial: the hosts data version when empty, the etcd revision of its last change when
+	// auto, or soaSerialValue when manual
+	soaSerial      string
+	soaSerialValue uint32
+
+	// nameservers of the zones, answered for NS queries at the zone apex
+	nameservers []string
+
+	// rules to order the A and AAAA answers by client network
+	sortlist []sortRule
+
+	// order of the A and AAAA answers when no sortlist rule matches: none, sort or round_robin
+	loadbalance string
+
+	// maximum number of records in an answer, unlimited if 0
+	maxAnswers int
+
+	// answer ANY queries with a single HINFO record as in RFC 8482
+	minimalAny bool
+
+	// leave the NS records and glue out of positive answers
+	minimal bool
+
+	// block size replies are padded to when the query asks for padding, disabled if 0
+	padding int
+
+	// log every answered query as a json line
+	queryLog bool
+
+	// client networks allowed to send dynamic updates, updates are disabled when empty
+	updateNets []*net.IPNet
 }
 
 func newOptions() *options {
@@ -48,6 +133,14 @@ func newOptions() *options {
 	}
 }
 
+// ttlOf returns the TTL of the records of type rrtype.
+func (o *options) ttlOf(rrtype uint16) uint32 {
+	if ttl, ok := o.ttlOverride[rrtype]; ok {
+		return ttl
+	}
+	return o.ttl
+}
+
 // Map contains the IPv4/IPv6 and reverse mapping.
 type Map struct {
 	// Key for the list of literal IP addresses must be a FQDN lowercased host name.
@@ -58,6 +151,10 @@ type Map struct {
 	// including IPv6 address without zone identifier.
 	// We don't support old-classful IP address notation.
 	addr map[string][]string
+
+	// Key for the set of names that own addresses or have descendants that do,
+	// a lowercased FQDN host name.
+	names map[string]struct{}
 }
 
 func newMap() *Map {
@@ -65,6 +162,7 @@ func newMap() *Map {
 		name4: make(map[string][]net.IP),
 		name6: make(map[string][]net.IP),
 		addr:  make(map[string][]string),
+		names: make(map[string]struct{}),
 	}
 }
 
@@ -96,6 +194,16 @@ type Hostsfile struct {
 	// inline saves the hosts file that is inlined in a Corefile.
 	inline *Map
 
+	// static saves the last resort entries of the Corefile, they are only
+	// served while etcd can't be read.
+	static *Map
+	// the static entries of the Corefile, without the ones of the fallback file
+	staticLines []string
+
+	// local hosts file whose entries are added to the static entries, and its last read version
+	fallbackFile    string
+	fallbackModTime time.Time
+
 	// etcd tls config
 	etcdTLSConfig *tls.Config
 
@@ -106,57 +214,264 @@ type Hostsfile struct {
 	// etcd endpoints
 	etcdEndpoints []string
 
-	// etcd v3 client
-	etcdClient *clientv3.Client
+	// SRV record the etcd endpoints are discovered from, refreshed periodically
+	etcdDiscoverSRV string
+	// looks up the SRV record of etcdDiscoverSRV, net.LookupSRV
+	lookupSRV func(service, proto, name string) (string, []*net.SRV, error)
+
+	// etcd v3 client, it is replaced when it fails to reach etcd for too long
+	etcdClient     *clientv3.Client
+	etcdClientLock sync.RWMutex
+	// creates the etcd v3 client that replaces etcdClient, clientv3.New
+	newClient func(clientv3.Config) (*clientv3.Client, error)
+
+	// etcd v3 client of the fallback endpoints, used when the etcd client fails with a
+	// transient error, nil if there are no fallback endpoints
+	etcdFallbackEndpoints []string
+	etcdFallbackClient    *clientv3.Client
+
+	// closed to stop the watch of periodicHostsUpdate, which closes watchDone once it stopped
+	watchStop chan bool
+	watchDone chan bool
+
+	// number of consecutive reads of the hosts key that failed on the primary and the fallback
+	// endpoints, accessed atomically
+	etcdFailures int32
+
+	// number of consecutive failed reads of the hosts key from the primary endpoints, accessed atomically
+	etcdPrimaryFailures int32
+
+	// set to 1 once the hosts key has been read from etcd, accessed atomically
+	etcdRead int32
 
 	// etcd client timeout
 	etcdTimeout time.Duration
 
+	// etcd client dial timeout, and the interval and timeout of the keepalive probes
+	// that detect dead connections
+	etcdDialTimeout      time.Duration
+	etcdKeepAlive        time.Duration
+	etcdKeepAliveTimeout time.Duration
+
+	// retries of a failed etcd get and the initial interval between them,
+	// the interval doubles after every retry
+	etcdRetries       int
+	etcdRetryInterval time.Duration
+
 	// etcd key
 	etcdHostsKey string
 
-	// etcdKeyVersion are only read and modified by a single goroutine
+	// etcd key of the unhealthy addresses that are left out of answers, and these addresses
+	etcdHealthKey string
+	unhealthy     map[string]bool
+
+	// read the values of all keys under etcd key instead of the key itself
+	etcdPrefix bool
+
+	// etcd revision the hosts key is read at, the latest revision is read when 0
+	etcdRevision int64
+
+	// http api listen address, the api is disabled when empty
+	apiAddr string
+
+	// etcd endpoint status of the last version.etcdhosts query and when it was taken
+	statusLock  sync.Mutex
+	statusCache []string
+	statusTime  time.Time
+
+	// version of the hosts key the hosts data was parsed from, guarded by the lock like hmap
 	etcdKeyVersion int64
+	// etcd revision of the last change of the hosts data
+	etcdModRevision int64
 
 	options *options
 }
 
+// etcdConfig returns the etcd v3 client config.
+func (h *Hostsfile) etcdConfig() clientv3.Config {
+	return clientv3.Config{
+		Username:             h.etcdUserName,
+		Password:             h.etcdPassword,
+		Endpoints:            h.etcdEndpoints,
+		DialTimeout:          h.etcdDialTimeout,
+		DialKeepAliveTime:    h.etcdKeepAlive,
+		DialKeepAliveTimeout: h.etcdKeepAliveTimeout,
+		TLS:                  h.etcdTLSConfig,
+	}
+}
+
+// client returns the current etcd v3 client.
+func (h *Hostsfile) client() *clientv3.Client {
+	h.etcdClientLock.RLock()
+	defer h.etcdClientLock.RUnlock()
+	return h.etcdClient
+}
+
+// reconnect replaces the etcd v3 client with a new one, the old client is closed
+// once requests that are still using it had the time to finish.
+func (h *Hostsfile) reconnect() error {
+	cli, err := h.newClient(h.etcdConfig())
+	if err != nil {
+		return err
+	}
+
+	h.etcdClientLock.Lock()
+	old := h.etcdClient
+	h.etcdClient = cli
+	h.etcdClientLock.Unlock()
+
+	time.AfterFunc(h.etcdTimeout, func() { _ = old.Close() })
+	return nil
+}
+
 // readHosts determines if the cached data needs to be updated based on the size and modification time of the hostsfile.
 func (h *Hostsfile) readHosts() {
 
 	ctx, cancel := context.WithTimeout(context.Background(), h.etcdTimeout)
 	defer cancel()
-	getResp, err := h.etcdClient.Get(ctx, h.etcdHostsKey)
+	getResp, err := h.getHosts(ctx, h.client())
 	if err != nil {
-		log.Errorf("failed to get etcd key [%s]: %s", h.etcdHostsKey, err.Error())
-		return
-	}
+		atomic.AddInt32(&h.etcdPrimaryFailures, 1)
+		if h.etcdFallbackClient == nil || !retryable(err) {
+			atomic.AddInt32(&h.etcdFailures, 1)
+			log.Errorf("failed to get etcd key [%s]: %s", h.etcdHostsKey, err.Error())
+			return
+		}
 
-	if len(getResp.Kvs) != 1 {
-		log.Errorf("invalid etcd response: %d", len(getResp.Kvs))
-		return
+		log.Warningf("failed to get etcd key [%s], reading from fallback endpoints: %s", h.etcdHostsKey, err.Error())
+		fallbackCtx, fallbackCancel := context.WithTimeout(context.Background(), h.etcdTimeout)
+		defer fallbackCancel()
+		getResp, err = h.getHosts(fallbackCtx, h.etcdFallbackClient)
+		if err != nil {
+			atomic.AddInt32(&h.etcdFailures, 1)
+			log.Errorf("failed to get etcd key [%s] from fallback endpoints: %s", h.etcdHostsKey, err.Error())
+			return
+		}
+	} else {
+		atomic.StoreInt32(&h.etcdPrimaryFailures, 0)
+	}
+	atomic.StoreInt32(&h.etcdFailures, 0)
+	atomic.StoreInt32(&h.etcdRead, 1)
+
+	// A typo in the key directive reads nothing, which would otherwise only show as NXDOMAIN
+	// answers. etcd may be populated later, so this is just a warning.
+	if len(getResp.Kvs) == 0 {
+		if h.etcdPrefix {
+			log.Warningf("no keys found under etcd key prefix [%s], check the key directive", h.hostsKey())
+		} else {
+			log.Warningf("etcd key [%s] not found, check the key directive", h.etcdHostsKey)
+			return
+		}
 	}
 
-	h.RLock()
-	version := h.etcdKeyVersion
-	h.RUnlock()
+	var data []byte
+	var keyVersion, modRevision int64
+	if h.etcdPrefix {
+		// Merge the values of all keys under the prefix, a deleted key doesn't leave a
+		// trace in the remaining ones so they are always parsed again.
+		var buf bytes.Buffer
+		for _, kv := range getResp.Kvs {
+			buf.Write(kv.Value)
+			buf.WriteByte('\n')
+			if kv.ModRevision > keyVersion {
+				keyVersion = kv.ModRevision
+			}
+		}
+		data = buf.Bytes()
+		// The largest ModRevision goes back when the last changed key is deleted, the revision
+		// of the read never does.
+		modRevision = getResp.Header.Revision
+	} else {
+		if len(getResp.Kvs) != 1 {
+			log.Errorf("invalid etcd response: %d", len(getResp.Kvs))
+			return
+		}
 
-	// if version not changed, skip reading
-	if version == getResp.Kvs[0].Version {
-		return
+		h.RLock()
+		version := h.etcdKeyVersion
+		h.RUnlock()
+
+		// if version not changed, skip reading
+		if version == getResp.Kvs[0].Version {
+			return
+		}
+		data, keyVersion = getResp.Kvs[0].Value, getResp.Kvs[0].Version
+		modRevision = getResp.Kvs[0].ModRevision
 	}
 
-	newMap := h.parse(bytes.NewReader(getResp.Kvs[0].Value))
+	newMap := h.parse(bytes.NewReader(data))
 	log.Debugf("Parsed hosts file into %d entries", newMap.Len())
 
 	h.Lock()
 	h.hmap = newMap
 	// Update the data cache.
-	h.etcdKeyVersion = getResp.Kvs[0].Version
+	h.etcdKeyVersion = keyVersion
+	h.etcdModRevision = modRevision
 	hostsEntries.WithLabelValues().Set(float64(h.inline.Len() + h.hmap.Len()))
 	h.Unlock()
 }
 
+// getHosts gets the hosts key from etcd, transient errors are retried until the retries
+// are used up or ctx is done.
+func (h *Hostsfile) getHosts(ctx context.Context, cli *clientv3.Client) (*clientv3.GetResponse, error) {
+	interval := h.etcdRetryInterval
+	for i := 0; ; i++ {
+		var opts []clientv3.OpOption
+		if h.etcdPrefix {
+			opts = append(opts, clientv3.WithPrefix())
+		}
+		if h.etcdRevision > 0 {
+			opts = append(opts, clientv3.WithRev(h.etcdRevision))
+		}
+		getResp, err := cli.Get(ctx, h.hostsKey(), opts...)
+		if err == nil || i >= h.etcdRetries || !retryable(err) {
+			return getResp, err
+		}
+
+		log.Warningf("failed to get etcd key [%s], retrying in %s: %s", h.etcdHostsKey, interval, err.Error())
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(interval):
+		}
+		interval *= 2
+	}
+}
+
+// retryable reports whether err is a transient etcd error.
+func retryable(err error) bool {
+	if err == context.DeadlineExceeded {
+		return true
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// hostsKey returns the etcd key or key prefix the hosts data is read from.
+func (h *Hostsfile) hostsKey() string {
+	if h.etcdPrefix {
+		return h.etcdHostsKey + "/"
+	}
+	return h.etcdHostsKey
+}
+
+// etcdDown reports whether etcd has not been read yet or the last read failed.
+func (h *Hostsfile) etcdDown() bool {
+	return atomic.LoadInt32(&h.etcdRead) == 0 || atomic.LoadInt32(&h.etcdFailures) > 0
+}
+
+func (h *Hostsfile) initStatic(static []string) {
+	if len(static) == 0 {
+		return
+	}
+
+	h.staticLines = static
+	h.static = h.parse(strings.NewReader(strings.Join(static, "\n")))
+}
+
 func (h *Hostsfile) initInline(inline []string) {
 	if len(inline) == 0 {
 		return
@@ -171,37 +486,41 @@ func (h *Hostsfile) parse(r io.Reader) *Map {
 
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
-		line := scanner.Bytes()
-		if i := bytes.Index(line, []byte{'#'}); i >= 0 {
-			// Discard comments.
-			line = line[0:i]
-		}
-		f := bytes.Fields(line)
-		if len(f) < 2 {
-			continue
-		}
-		addr := parseIP(string(f[0]))
-		if addr == nil {
+		addr, names, err := parseLine(scanner.Bytes())
+		if addr == nil || err != nil {
 			continue
 		}
 
-		family := 0
-		if addr.To4() != nil {
-			family = 1
-		} else {
-			family = 2
-		}
+		family := ipFamily(addr)
 
-		for i := 1; i < len(f); i++ {
-			name := plugin.Name(string(f[i])).Normalize()
-			if plugin.Zones(h.Origins).Matches(name) == "" {
+		for _, n := range names {
+			name := normalizeName(string(n))
+			zone := plugin.Zones(h.Origins).Matches(name)
+			if zone == "" {
 				// name is not in Origins
 				continue
 			}
+			// Record the name and its ancestors up to the zone, so empty non-terminals exist.
+			for n := name; dns.IsSubDomain(zone, n); {
+				hmap.names[n] = struct{}{}
+				off, end := dns.NextLabel(n, 0)
+				if end {
+					break
+				}
+				n = n[off:]
+			}
 			switch family {
 			case 1:
+				if containsIP(hmap.name4[name], addr) {
+					// duplicate entry
+					continue
+				}
 				hmap.name4[name] = append(hmap.name4[name], addr)
 			case 2:
+				if containsIP(hmap.name6[name], addr) {
+					// duplicate entry
+					continue
+				}
 				hmap.name6[name] = append(hmap.name6[name], addr)
 			default:
 				continue
@@ -216,6 +535,27 @@ func (h *Hostsfile) parse(r io.Reader) *Map {
 	return hmap
 }
 
+// parseLine splits a hosts line into its address and host names, comments are discarded.
+// A nil address and error are returned for blank lines.
+func parseLine(line []byte) (net.IP, [][]byte, error) {
+	if i := bytes.Index(line, []byte{'#'}); i >= 0 {
+		// Discard comments.
+		line = line[0:i]
+	}
+	f := bytes.Fields(line)
+	if len(f) == 0 {
+		return nil, nil, nil
+	}
+	if len(f) < 2 {
+		return nil, nil, fmt.Errorf("missing host name")
+	}
+	addr := parseIP(string(f[0]))
+	if addr == nil {
+		return nil, nil, fmt.Errorf("invalid address '%s'", f[0])
+	}
+	return addr, f[1:], nil
+}
+
 // lookupStaticHost looks up the IP addresses for the given host from the hosts file.
 func (h *Hostsfile) lookupStaticHost(m map[string][]net.IP, host string) []net.IP {
 	h.RLock()
@@ -237,37 +577,109 @@ func (h *Hostsfile) lookupStaticHost(m map[string][]net.IP, host string) []net.I
 // LookupStaticHostV4 looks up the IPv4 addresses for the given host from the hosts file.
 func (h *Hostsfile) LookupStaticHostV4(host string) []net.IP {
 	host = strings.ToLower(host)
-	ip1 := h.lookupStaticHost(h.hmap.name4, host)
+	// readHosts and readFallbackFile replace the maps, the maps of a parsed Map are never changed
+	h.RLock()
+	hmap, static := h.hmap, h.static
+	h.RUnlock()
+	ip1 := h.lookupStaticHost(hmap.name4, host)
 	ip2 := h.lookupStaticHost(h.inline.name4, host)
-	return append(ip1, ip2...)
+	if h.etcdDown() {
+		ip2 = mergeIPs(ip2, h.lookupStaticHost(static.name4, host))
+	}
+	return mergeIPs(ip1, ip2)
 }
 
 // LookupStaticHostV6 looks up the IPv6 addresses for the given host from the hosts file.
 func (h *Hostsfile) LookupStaticHostV6(host string) []net.IP {
 	host = strings.ToLower(host)
-	ip1 := h.lookupStaticHost(h.hmap.name6, host)
+	// readHosts and readFallbackFile replace the maps, the maps of a parsed Map are never changed
+	h.RLock()
+	hmap, static := h.hmap, h.static
+	h.RUnlock()
+	ip1 := h.lookupStaticHost(hmap.name6, host)
 	ip2 := h.lookupStaticHost(h.inline.name6, host)
-	return append(ip1, ip2...)
+	if h.etcdDown() {
+		ip2 = mergeIPs(ip2, h.lookupStaticHost(static.name6, host))
+	}
+	return mergeIPs(ip1, ip2)
+}
+
+// NameExists reports whether the host or any name below it has addresses in the hosts file.
+func (h *Hostsfile) NameExists(host string) bool {
+	host = strings.ToLower(host)
+
+	h.RLock()
+	defer h.RUnlock()
+	if _, ok := h.hmap.names[host]; ok {
+		return true
+	}
+	if _, ok := h.inline.names[host]; ok {
+		return true
+	}
+	if h.etcdDown() {
+		_, ok := h.static.names[host]
+		return ok
+	}
+	return false
 }
 
 // LookupStaticAddr looks up the hosts for the given address from the hosts file.
+// Both the in-addr.arpa and the nibble format ip6.arpa addresses map to the same
+// canonical form the reverse entries are keyed with.
 func (h *Hostsfile) LookupStaticAddr(addr string) []string {
-	addr = parseIP(addr).String()
-	if addr == "" {
+	ip := parseIP(addr)
+	if ip == nil {
 		return nil
 	}
+	addr = ip.String()
 
 	h.RLock()
 	defer h.RUnlock()
 	hosts1 := h.hmap.addr[addr]
 	hosts2 := h.inline.addr[addr]
+	if h.etcdDown() {
+		hosts2 = append(hosts2[:len(hosts2):len(hosts2)], h.static.addr[addr]...)
+	}
 
 	if len(hosts1) == 0 && len(hosts2) == 0 {
 		return nil
 	}
 
-	hostsCp := make([]string, len(hosts1)+len(hosts2))
+	hostsCp := make([]string, len(hosts1), len(hosts1)+len(hosts2))
 	copy(hostsCp, hosts1)
-	copy(hostsCp[len(hosts1):], hosts2)
+	for _, host := range hosts2 {
+		if !containsString(hostsCp, host) {
+			hostsCp = append(hostsCp, host)
+		}
+	}
 	return hostsCp
 }
+
+// mergeIPs appends the addresses of ips2 that are not in ips1 to ips1, so an address that is
+// both in the hosts data and the Corefile is only answered once.
+func mergeIPs(ips1, ips2 []net.IP) []net.IP {
+	for _, ip := range ips2 {
+		if !containsIP(ips1, ip) {
+			ips1 = append(ips1, ip)
+		}
+	}
+	return ips1
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, i := range ips {
+		if i.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(s []string, e string) bool {
+	for _, i := range s {
+		if i == e {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,410 @@
+package etcdhosts
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.etcd.io/etcd/clientv3"
+	pb "go.etcd.io/etcd/etcdserver/etcdserverpb"
+	"go.etcd.io/etcd/mvcc/mvccpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/miekg/dns"
+)
+
+const testHostsKey = "/etcdhosts"
+
+// fakeKV is an in-memory etcd KV. The next fail calls of Get return err.
+type fakeKV struct {
+	clientv3.KV
+
+	sync.Mutex
+	kvs  map[string]*mvccpb.KeyValue
+	rev  int64
+	fail int
+	err  error
+	gets int
+}
+
+// newFakeClient returns an etcd client that reads and writes kvs in memory, its watches never
+// see a change.
+func newFakeClient(kvs map[string]string) (*clientv3.Client, *fakeKV) {
+	kv := &fakeKV{kvs: make(map[string]*mvccpb.KeyValue)}
+	for k, v := range kvs {
+		kv.put(k, v)
+	}
+	cli := clientv3.NewCtxClient(context.Background())
+	cli.KV, cli.Watcher = kv, &fakeWatcher{}
+	return cli, kv
+}
+
+// fakeWatcher is a watcher whose watches end when their context is done.
+type fakeWatcher struct {
+	clientv3.Watcher
+
+	sync.Mutex
+	closed bool
+}
+
+func (w *fakeWatcher) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	ch := make(chan clientv3.WatchResponse)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func (w *fakeWatcher) Close() error {
+	w.Lock()
+	defer w.Unlock()
+	w.closed = true
+	return nil
+}
+
+// put stores value at key with a new revision, the caller holds the lock.
+func (kv *fakeKV) put(key, value string) {
+	kv.rev++
+	old, ok := kv.kvs[key]
+	if !ok {
+		old = &mvccpb.KeyValue{CreateRevision: kv.rev}
+	}
+	kv.kvs[key] = &mvccpb.KeyValue{
+		Key:            []byte(key),
+		Value:          []byte(value),
+		CreateRevision: old.CreateRevision,
+		ModRevision:    kv.rev,
+		Version:        old.Version + 1,
+	}
+}
+
+func (kv *fakeKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	kv.Lock()
+	kv.gets++
+	if kv.fail > 0 {
+		kv.fail--
+		err := kv.err
+		kv.Unlock()
+		return nil, err
+	}
+	kv.Unlock()
+
+	// the etcd client turns the options into the range request
+	return clientv3.NewKVFromKVClient(&fakeRemote{kv: kv}, nil).Get(ctx, key, opts...)
+}
+
+// fakeRemote serves the range requests of fakeKV in key order, there is no history so the
+// revision of a request is ignored.
+type fakeRemote struct {
+	pb.KVClient
+	kv *fakeKV
+}
+
+func (r *fakeRemote) Range(ctx context.Context, in *pb.RangeRequest, opts ...grpc.CallOption) (*pb.RangeResponse, error) {
+	r.kv.Lock()
+	defer r.kv.Unlock()
+
+	var keys []string
+	for k := range r.kv.kvs {
+		if k == string(in.Key) || (len(in.RangeEnd) > 0 && k >= string(in.Key) && k < string(in.RangeEnd)) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	resp := &pb.RangeResponse{Header: &pb.ResponseHeader{Revision: r.kv.rev}, Count: int64(len(keys))}
+	if in.Limit > 0 && int64(len(keys)) > in.Limit {
+		keys, resp.More = keys[:in.Limit], true
+	}
+	for _, k := range keys {
+		resp.Kvs = append(resp.Kvs, r.kv.kvs[k])
+	}
+	return resp, nil
+}
+
+func (kv *fakeKV) Txn(ctx context.Context) clientv3.Txn { return &fakeTxn{kv: kv} }
+
+// fakeTxn is a transaction of fakeKV, only ModRevision equality comparisons and puts are supported.
+type fakeTxn struct {
+	kv   *fakeKV
+	cmps []clientv3.Cmp
+	ops  []clientv3.Op
+}
+
+func (t *fakeTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps = append(t.cmps, cs...)
+	return t
+}
+
+func (t *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.ops = append(t.ops, ops...)
+	return t
+}
+
+func (t *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn { return t }
+
+func (t *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	t.kv.Lock()
+	defer t.kv.Unlock()
+
+	for _, c := range t.cmps {
+		var rev int64
+		if v, ok := t.kv.kvs[string(c.KeyBytes())]; ok {
+			rev = v.ModRevision
+		}
+		target, ok := c.TargetUnion.(*pb.Compare_ModRevision)
+		if !ok || c.Result != pb.Compare_EQUAL || target.ModRevision != rev {
+			return &clientv3.TxnResponse{Header: &pb.ResponseHeader{Revision: t.kv.rev}}, nil
+		}
+	}
+	for _, op := range t.ops {
+		if op.IsPut() {
+			t.kv.put(string(op.KeyBytes()), string(op.ValueBytes()))
+		}
+	}
+	return &clientv3.TxnResponse{Header: &pb.ResponseHeader{Revision: t.kv.rev}, Succeeded: true}, nil
+}
+
+// newTestHostsfile returns a Hostsfile that reads the hosts key from cli.
+func newTestHostsfile(cli *clientv3.Client) *Hostsfile {
+	return &Hostsfile{
+		Origins:      []string{"example.org."},
+		hmap:         newMap(),
+		inline:       newMap(),
+		static:       newMap(),
+		options:      newOptions(),
+		etcdClient:   cli,
+		etcdHostsKey: testHostsKey,
+		etcdTimeout:  time.Second,
+	}
+}
+
+func TestReadHostsFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		fallbackDown bool
+		down         bool
+		ips          int
+	}{
+		{"fallback up", false, false, 2},
+		{"fallback down", true, true, 0},
+	}
+
+	for _, tc := range tests {
+		primary, primaryKV := newFakeClient(map[string]string{testHostsKey: hostsExample})
+		primaryKV.fail, primaryKV.err = 1, status.Error(codes.Unavailable, "primary down")
+		fallback, fallbackKV := newFakeClient(map[string]string{testHostsKey: hostsExample})
+		if tc.fallbackDown {
+			fallbackKV.fail, fallbackKV.err = 1, status.Error(codes.Unavailable, "fallback down")
+		}
+
+		h := newTestHostsfile(primary)
+		h.etcdFallbackClient = fallback
+		h.readHosts()
+
+		if down := h.etcdDown(); down != tc.down {
+			t.Errorf("%s: expected etcd down to be %t, got %t", tc.name, tc.down, down)
+		}
+		if ips := h.LookupStaticHostV4("www.example.org."); len(ips) != tc.ips {
+			t.Errorf("%s: expected %d addresses, got %v", tc.name, tc.ips, ips)
+		}
+		// The primary endpoints are reconnected even though the fallback endpoints serve.
+		if h.etcdPrimaryFailures != 1 {
+			t.Errorf("%s: expected 1 primary failure, got %d", tc.name, h.etcdPrimaryFailures)
+		}
+	}
+}
+
+func TestGetHostsRetry(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	tests := []struct {
+		name    string
+		fail    int
+		err     error
+		retries int
+		gets    int
+		ok      bool
+	}{
+		{"no failure", 0, nil, 2, 1, true},
+		{"transient failure", 1, unavailable, 2, 2, true},
+		{"retries used up", 3, unavailable, 2, 3, false},
+		{"no retries", 1, unavailable, 0, 1, false},
+		{"permanent failure", 1, errors.New("permission denied"), 2, 1, false},
+	}
+
+	for _, tc := range tests {
+		cli, kv := newFakeClient(map[string]string{testHostsKey: hostsExample})
+		kv.fail, kv.err = tc.fail, tc.err
+
+		h := newTestHostsfile(cli)
+		h.etcdRetries = tc.retries
+		h.etcdRetryInterval = time.Millisecond
+
+		resp, err := h.getHosts(context.Background(), cli)
+		if tc.ok && (err != nil || len(resp.Kvs) != 1) {
+			t.Errorf("%s: expected the hosts key, got %v, %v", tc.name, resp, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+		if kv.gets != tc.gets {
+			t.Errorf("%s: expected %d reads, got %d", tc.name, tc.gets, kv.gets)
+		}
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{context.DeadlineExceeded, true},
+		{status.Error(codes.Unavailable, "unavailable"), true},
+		{status.Error(codes.DeadlineExceeded, "deadline exceeded"), true},
+		{status.Error(codes.PermissionDenied, "permission denied"), false},
+		{context.Canceled, false},
+		{errors.New("error"), false},
+	}
+
+	for _, tc := range tests {
+		if got := retryable(tc.err); got != tc.retryable {
+			t.Errorf("%v: expected retryable %t, got %t", tc.err, tc.retryable, got)
+		}
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		addr  string
+		names []string
+		err   bool
+	}{
+		{"", "", nil, false},
+		{"# comment", "", nil, false},
+		{"10.0.0.1 example.org www.example.org", "10.0.0.1", []string{"example.org", "www.example.org"}, false},
+		{"10.0.0.1\texample.org # comment", "10.0.0.1", []string{"example.org"}, false},
+		{"fe80::1%eth0 example.org", "fe80::1", []string{"example.org"}, false},
+		{"10.0.0.1", "", nil, true},
+		{"example.org 10.0.0.1", "", nil, true},
+	}
+
+	for _, tc := range tests {
+		addr, names, err := parseLine([]byte(tc.line))
+		if tc.err != (err != nil) {
+			t.Errorf("%q: expected error %t, got %v", tc.line, tc.err, err)
+			continue
+		}
+		if tc.addr != "" && !addr.Equal(net.ParseIP(tc.addr)) {
+			t.Errorf("%q: expected address %s, got %s", tc.line, tc.addr, addr)
+		}
+		if len(names) != len(tc.names) {
+			t.Errorf("%q: expected names %v, got %q", tc.line, tc.names, names)
+			continue
+		}
+		for i := range names {
+			if string(names[i]) != tc.names[i] {
+				t.Errorf("%q: expected names %v, got %q", tc.line, tc.names, names)
+			}
+		}
+	}
+}
+
+func TestNormalizeName(t *testing.T) {
+	tests := []struct {
+		name, expected string
+	}{
+		{"example.org", "example.org."},
+		{"www.example.org.", "www.example.org."},
+		{"WWW.Example.ORG", "www.example.org."},
+		{"bücher.example", "xn--bcher-kva.example."},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example."},
+		{"BÜCHER.Example", "xn--bcher-kva.example."},
+		{"ｂüｃｈｅｒ.example", "xn--bcher-kva.example."},
+		{"_http._tcp.example.org", "_http._tcp.example.org."},
+	}
+
+	for _, tc := range tests {
+		if got := normalizeName(tc.name); got != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expected, got)
+		}
+	}
+}
+
+func TestReadHostsDuplicates(t *testing.T) {
+	cli, _ := newFakeClient(map[string]string{
+		testHostsKey + "/a": "10.0.0.1 www.example.org\n10.0.0.1 www.example.org\nfd00::1 www.example.org\n",
+		// the same address under another key, IPv6 in another notation
+		testHostsKey + "/b": "10.0.0.1 www.example.org\n10.0.0.2 www.example.org\nfd00:0::1 www.example.org\n",
+	})
+	h := newTestHostsfile(cli)
+	h.etcdPrefix = true
+	// an inline address the hosts data repeats
+	h.inline.name4["www.example.org."] = []net.IP{net.ParseIP("10.0.0.2")}
+	h.readHosts()
+
+	if ips := h.LookupStaticHostV4("www.example.org."); len(ips) != 2 {
+		t.Errorf("expected 2 IPv4 addresses, got %v", ips)
+	}
+	if ips := h.LookupStaticHostV6("www.example.org."); len(ips) != 1 {
+		t.Errorf("expected 1 IPv6 address, got %v", ips)
+	}
+}
+
+func TestServeDNSStatic(t *testing.T) {
+	tests := []struct {
+		name string
+		fail int
+		ip   string
+	}{
+		{"etcd up", 0, "10.0.0.1"},
+		{"etcd down", 1, "10.0.0.99"},
+	}
+
+	for _, tc := range tests {
+		cli, kv := newFakeClient(map[string]string{testHostsKey: "10.0.0.1 monitor.example.org\n"})
+		kv.fail, kv.err = tc.fail, errors.New("permission denied")
+
+		h := Hosts{Hostsfile: newTestHostsfile(cli)}
+		h.initStatic([]string{"10.0.0.99 monitor.example.org"})
+		h.readHosts()
+
+		rec := serve(t, h, "monitor.example.org.", dns.TypeA)
+		if rec.Msg == nil || len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.A).A.String() != tc.ip {
+			t.Errorf("%s: expected the address %s, got %v", tc.name, tc.ip, rec.Msg)
+		}
+	}
+}
+
+func TestReadHostsMissingKey(t *testing.T) {
+	tests := []struct {
+		prefix  bool
+		warning string
+	}{
+		{false, "etcd key [/etcdhosts] not found, check the key directive"},
+		{true, "no keys found under etcd key prefix [/etcdhosts/], check the key directive"},
+	}
+
+	for _, tc := range tests {
+		cli, _ := newFakeClient(map[string]string{"/other": hostsExample})
+		h := newTestHostsfile(cli)
+		h.etcdPrefix = tc.prefix
+
+		out := captureLog(h.readHosts)
+		if !strings.Contains(out, "[WARNING] plugin/etcdhosts: "+tc.warning) {
+			t.Errorf("prefix %t: expected the warning %q, got %q", tc.prefix, tc.warning, out)
+		}
+		// etcd may be populated later, the empty hosts data is served
+		if h.etcdDown() {
+			t.Errorf("prefix %t: expected etcd to be up", tc.prefix)
+		}
+	}
+}
@@ -11,8 +11,32 @@ var (
 	// hostsEntries is the combined number of entries in hosts and Corefile.
 	hostsEntries = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: plugin.Namespace,
-		Subsystem: "etcdhosts",
+		Subsystem: pluginName,
 		Name:      "entries",
 		Help:      "The combined number of entries in hosts and Corefile.",
 	}, []string{})
+
+	// queryCount is the number of queries answered by etcdhosts by type and rcode.
+	queryCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "queries_total",
+		Help:      "The number of queries answered by type and rcode.",
+	}, []string{"type", "rcode"})
+
+	// etcdDownCount is the number of queries answered while etcd can't be read.
+	etcdDownCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "etcd_down_queries_total",
+		Help:      "The number of queries answered while etcd can't be read.",
+	}, []string{})
+
+	// rateLimitedCount is the number of queries limited by ratelimit.
+	rateLimitedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: plugin.Namespace,
+		Subsystem: pluginName,
+		Name:      "ratelimited_total",
+		Help:      "The number of queries limited by ratelimit.",
+	}, []string{})
 )
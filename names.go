@@ -0,0 +1,57 @@
+package etcdhosts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.etcd.io/etcd/clientv3"
+
+	"github.com/coredns/coredns/plugin"
+)
+
+// ListNames gets the hosts data stored at key and returns the sorted names in the given zones
+// that have addresses, without the trailing dot.
+func ListNames(ctx context.Context, client *clientv3.Client, key string, origins []string) ([]string, error) {
+	getResp, err := client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(getResp.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd key [%s] not found", key)
+	}
+
+	zones := make([]string, len(origins))
+	for i := range origins {
+		zones[i] = plugin.Name(origins[i]).Normalize()
+	}
+	return listNames(getResp.Kvs[0].Value, zones), nil
+}
+
+// listNames returns the sorted names of the hosts data that are in origins.
+func listNames(data []byte, origins []string) []string {
+	seen := make(map[string]bool)
+	var names []string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		addr, fields, err := parseLine(scanner.Bytes())
+		if addr == nil || err != nil {
+			continue
+		}
+		for _, f := range fields {
+			name := normalizeName(string(f))
+			if seen[name] || plugin.Zones(origins).Matches(name) == "" {
+				continue
+			}
+			seen[name] = true
+			names = append(names, strings.TrimSuffix(name, "."))
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,26 @@
+package etcdhosts
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestListNames(t *testing.T) {
+	data := `10.0.0.1 example.org www.example.org
+10.0.0.2 WWW.example.org db.example.org
+fd00::1 www.example.org api.example.org
+10.0.0.3 www.example.net
+10.0.0.4
+`
+	cli, _ := newFakeClient(map[string]string{testHostsKey: data})
+
+	names, err := ListNames(context.Background(), cli, testHostsKey, []string{"example.org"})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := []string{"api.example.org", "db.example.org", "example.org", "www.example.org"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected names %v, got %v", expected, names)
+	}
+}
@@ -0,0 +1,44 @@
+package etcdhosts
+
+import (
+	"github.com/miekg/dns"
+)
+
+// pad adds an EDNS0 padding option to the reply m so its size is a multiple of blockSize, as in
+// RFC 7830 and RFC 8467, without growing it beyond size. Only replies to queries that have a
+// padding option themselves are padded.
+func pad(req, m *dns.Msg, blockSize, size int) {
+	opt := req.IsEdns0()
+	if opt == nil || m.IsEdns0() == nil {
+		return
+	}
+	requested := false
+	for _, o := range opt.Option {
+		if o.Option() == dns.EDNS0PADDING {
+			requested = true
+			break
+		}
+	}
+	if !requested {
+		return
+	}
+
+	padding := &dns.EDNS0_PADDING{}
+	replyOpt := m.IsEdns0()
+	replyOpt.Option = append(replyOpt.Option, padding)
+
+	// The padding must not push the reply over the client's buffer size, it would be truncated.
+	l := m.Len()
+	if l > size {
+		replyOpt.Option = replyOpt.Option[:len(replyOpt.Option)-1]
+		return
+	}
+	n := 0
+	if l%blockSize > 0 {
+		n = blockSize - l%blockSize
+	}
+	if n > size-l {
+		n = size - l
+	}
+	padding.Padding = make([]byte, n)
+}
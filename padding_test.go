@@ -0,0 +1,48 @@
+package etcdhosts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSPadding(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.options.padding = 468
+
+	for _, padded := range []bool{true, false} {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.org.", dns.TypeA)
+		m.SetEdns0(4096, false)
+		if padded {
+			opt := m.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_PADDING{})
+		}
+
+		rec := dnstest.NewRecorder(&test.ResponseWriter{})
+		if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		b, err := rec.Msg.Pack()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hasPadding := false
+		for _, o := range rec.Msg.IsEdns0().Option {
+			if o.Option() == dns.EDNS0PADDING {
+				hasPadding = true
+			}
+		}
+		if hasPadding != padded {
+			t.Errorf("padding requested %t: expected the padding option %t", padded, padded)
+		}
+		if padded && len(b)%468 != 0 {
+			t.Errorf("expected the reply to be padded to a multiple of 468, got %d", len(b))
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package etcdhosts
+
+import (
+	"encoding/json"
+
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// queryLog is a line of the json query log.
+type queryLog struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Rcode   string `json:"rcode"`
+	Client  string `json:"client"`
+	Answers int    `json:"answers"`
+	// etcd key and version of the hosts data the query was answered from
+	Key     string `json:"key"`
+	Version int64  `json:"version"`
+	// etcd can't be read, answers may come from the static entries
+	EtcdDown bool `json:"etcd_down"`
+}
+
+// writeMsg writes the reply m, padded when padding is enabled, counts it and logs it when the
+// query log is enabled.
+func (h Hosts) writeMsg(state request.Request, m *dns.Msg) {
+	if h.options.padding > 0 {
+		pad(state.Req, m, h.options.padding, state.Size())
+	}
+	_ = state.W.WriteMsg(m)
+
+	// Unknown types are counted together, so random types don't add label values.
+	qtype := "other"
+	if _, ok := dns.TypeToString[state.QType()]; ok {
+		qtype = state.Type()
+	}
+	queryCount.WithLabelValues(qtype, dns.RcodeToString[m.Rcode]).Inc()
+	// These answers may come from the static entries or the fallback file.
+	if h.etcdDown() {
+		etcdDownCount.WithLabelValues().Inc()
+	}
+
+	if !h.options.queryLog {
+		return
+	}
+
+	h.RLock()
+	version := h.etcdKeyVersion
+	h.RUnlock()
+
+	b, err := json.Marshal(queryLog{
+		Name:     state.Name(),
+		Type:     state.Type(),
+		Rcode:    dns.RcodeToString[m.Rcode],
+		Client:   state.IP(),
+		Answers:  len(m.Answer),
+		Key:      h.hostsKey(),
+		Version:  version,
+		EtcdDown: h.etcdDown(),
+	})
+	if err != nil {
+		return
+	}
+	log.Info(string(b))
+}
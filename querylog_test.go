@@ -0,0 +1,51 @@
+package etcdhosts
+
+import (
+	"bytes"
+	"encoding/json"
+	golog "log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// captureLog returns what is logged while f runs.
+func captureLog(f func()) string {
+	var buf bytes.Buffer
+	golog.SetOutput(&buf)
+	defer golog.SetOutput(os.Stderr)
+	f()
+	return buf.String()
+}
+
+func TestQueryLog(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.etcdHostsKey = testHostsKey
+	h.etcdKeyVersion = 7
+	h.options.queryLog = true
+
+	out := captureLog(func() { serve(t, h, "www.example.org.", dns.TypeA) })
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 1 || !strings.Contains(lines[0], "[INFO] plugin/etcdhosts: {") {
+		t.Fatalf("expected one query log line, got %q", out)
+	}
+	var l queryLog
+	if err := json.Unmarshal([]byte(lines[0][strings.Index(lines[0], "{"):]), &l); err != nil {
+		t.Fatalf("expected a json line, got %s", err)
+	}
+	expected := queryLog{
+		Name:    "www.example.org.",
+		Type:    "A",
+		Rcode:   "NOERROR",
+		Client:  "10.240.0.1",
+		Answers: 2,
+		Key:     testHostsKey,
+		Version: 7,
+	}
+	if l != expected {
+		t.Errorf("expected %+v, got %+v", expected, l)
+	}
+}
@@ -0,0 +1,73 @@
+package etcdhosts
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket rate limiter keyed by client ip.
+type rateLimiter struct {
+	sync.Mutex
+
+	// tokens added to a bucket per second and the size of a bucket
+	qps   float64
+	burst float64
+
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(qps, burst int) *rateLimiter {
+	return &rateLimiter{
+		qps:       float64(qps),
+		burst:     float64(burst),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow reports whether a query from ip may be answered and takes a token from its bucket if so.
+func (l *rateLimiter) allow(ip string) bool {
+	now := time.Now()
+
+	l.Lock()
+	defer l.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.qps
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops the buckets that are full again, so idle clients don't hold memory forever.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.qps >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package etcdhosts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSRateLimit(t *testing.T) {
+	h := newTestHosts(hostsExample)
+	h.limiter = newRateLimiter(1, 5)
+
+	query := func(w dns.ResponseWriter) (*dnstest.Recorder, int) {
+		m := new(dns.Msg)
+		m.SetQuestion("www.example.org.", dns.TypeA)
+		rec := dnstest.NewRecorder(w)
+		rcode, err := h.ServeDNS(context.Background(), rec, m)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		return rec, rcode
+	}
+
+	// a flood from one client is answered up to the burst
+	answered := 0
+	for i := 0; i < 20; i++ {
+		rec, _ := query(&test.ResponseWriter{})
+		if len(rec.Msg.Answer) > 0 {
+			answered++
+			continue
+		}
+		if !rec.Msg.Truncated {
+			t.Errorf("expected a limited UDP query to get a truncated reply, got %s", rec.Msg)
+		}
+	}
+	if answered != 5 {
+		t.Errorf("expected 5 answered queries, got %d", answered)
+	}
+
+	// the client is refused over TCP as well, without a reply
+	rec, rcode := query(&test.ResponseWriter{TCP: true})
+	if rcode != dns.RcodeRefused || rec.Msg != nil {
+		t.Errorf("expected a limited TCP query to be refused, got %s", dns.RcodeToString[rcode])
+	}
+
+	// another client is not affected
+	rec, _ = query(&test.ResponseWriter6{})
+	if len(rec.Msg.Answer) != 2 {
+		t.Errorf("expected another client to be answered, got %s", rec.Msg)
+	}
+}
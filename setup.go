@@ -2,8 +2,13 @@ package etcdhosts
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"go.etcd.io/etcd/clientv3"
@@ -14,48 +19,159 @@ import (
 	mwtls "github.com/coredns/coredns/plugin/pkg/tls"
 
 	"github.com/coredns/caddy"
+
+	"github.com/miekg/dns"
 )
 
-var log = clog.NewWithPlugin("etcdhosts")
+// pluginName is the name of the plugin in the Corefile and in plugin.cfg.
+const pluginName = "etcdhosts"
+
+var log = clog.NewWithPlugin(pluginName)
 
-func init() { plugin.Register("etcdhosts", setup) }
+func init() { plugin.Register(pluginName, setup) }
+
+const (
+	// interval to check whether etcd is reachable again after a failed read
+	healthCheckInterval = 10 * time.Second
+	// consecutive failed reads before the etcd client is replaced
+	reconnectFailures = 3
+	// bounds of the backoff between replacing the etcd client
+	minReconnectBackoff = 10 * time.Second
+	maxReconnectBackoff = 5 * time.Minute
+	// interval to look up the SRV record of discover_srv again
+	discoverInterval = time.Minute
+	// glue records added to the additional section of a reply
+	maxGlue = 16
+)
 
-func periodicHostsUpdate(h *Hosts) chan bool {
-	parseChan := make(chan bool)
+// periodicHostsUpdate watches the hosts data until parseChan is closed, done is closed once the
+// watch has stopped.
+func periodicHostsUpdate(h *Hosts) (parseChan chan bool, done chan bool) {
+	parseChan = make(chan bool)
+	done = make(chan bool)
 
 	go func() {
-		watchCh := h.etcdClient.Watch(context.Background(), h.etcdHostsKey)
+		defer close(done)
+
+		ticker := time.NewTicker(healthCheckInterval)
+		defer ticker.Stop()
+
+		// the endpoints are only refreshed when they are discovered from a SRV record
+		var discoverC <-chan time.Time
+		if h.etcdDiscoverSRV != "" {
+			discoverTicker := time.NewTicker(discoverInterval)
+			defer discoverTicker.Stop()
+			discoverC = discoverTicker.C
+		}
+
+		backoff := &reconnectBackoff{interval: minReconnectBackoff, last: time.Now()}
+
+		var watchOpts []clientv3.OpOption
+		if h.etcdPrefix {
+			watchOpts = append(watchOpts, clientv3.WithPrefix())
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		watchCh, healthCh := h.watch(ctx, watchOpts)
 		for {
 			select {
 			case <-parseChan:
+				cancel()
 				return
-			case <-watchCh:
+			case resp, ok := <-watchCh:
+				if !ok || resp.Err() != nil {
+					// The watch is broken, e.g. the cluster lost its leader, watch again.
+					cancel()
+					ctx, cancel = context.WithCancel(context.Background())
+					watchCh, healthCh = h.watch(ctx, watchOpts)
+				}
 				log.Info("etcdhosts reloading...")
 				h.readHosts()
+			case resp, ok := <-healthCh:
+				if !ok || resp.Err() != nil {
+					cancel()
+					ctx, cancel = context.WithCancel(context.Background())
+					watchCh, healthCh = h.watch(ctx, watchOpts)
+				}
+				h.readHealth()
+			case <-discoverC:
+				h.refreshEndpoints()
+			case <-ticker.C:
+				if err := h.readFallbackFile(); err != nil {
+					log.Errorf("failed to read fallback file: %s", err.Error())
+				}
+				if !h.superviseEtcd(backoff) {
+					continue
+				}
+				cancel()
+				ctx, cancel = context.WithCancel(context.Background())
+				watchCh, healthCh = h.watch(ctx, watchOpts)
+				h.readHosts()
+				h.readHealth()
 			}
 		}
 	}()
-	return parseChan
+	return parseChan, done
+}
+
+// reconnectBackoff is the interval between replacing the etcd client and when it was last replaced.
+type reconnectBackoff struct {
+	interval time.Duration
+	last     time.Time
+}
+
+// superviseEtcd reads the primary endpoints again while they fail and replaces the etcd client
+// once they failed reconnectFailures times in a row, it reports whether the client was replaced.
+func (h *Hosts) superviseEtcd(b *reconnectBackoff) bool {
+	// The primary endpoints are read again and reconnected while the fallback endpoints serve.
+	if atomic.LoadInt32(&h.etcdPrimaryFailures) == 0 {
+		b.interval = minReconnectBackoff
+		return false
+	}
+	h.readHosts()
+	if atomic.LoadInt32(&h.etcdPrimaryFailures) < reconnectFailures || time.Since(b.last) < b.interval {
+		return false
+	}
+
+	log.Warningf("failed to reach etcd %d times in a row, reconnecting", atomic.LoadInt32(&h.etcdPrimaryFailures))
+	b.last = time.Now()
+	if b.interval *= 2; b.interval > maxReconnectBackoff {
+		b.interval = maxReconnectBackoff
+	}
+	if err := h.reconnect(); err != nil {
+		log.Errorf("failed to create etcd client: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+// watch watches the hosts data and the health key until ctx is canceled, healthCh is nil when
+// there is no health key.
+func (h *Hosts) watch(ctx context.Context, opts []clientv3.OpOption) (watchCh, healthCh clientv3.WatchChan) {
+	ctx = clientv3.WithRequireLeader(ctx)
+	watchCh = h.client().Watch(ctx, h.hostsKey(), opts...)
+	if h.etcdHealthKey != "" {
+		healthCh = h.client().Watch(ctx, h.etcdHealthKey)
+	}
+	return watchCh, healthCh
 }
 
 func setup(c *caddy.Controller) error {
 	h, err := hostsParse(c)
 	if err != nil {
-		return plugin.Error("etcdhosts", err)
+		return plugin.Error(pluginName, err)
 	}
 
-	parseChan := periodicHostsUpdate(&h)
+	c.OnStartup(h.OnStartup)
+	c.OnShutdown(h.OnShutdown)
 
-	c.OnStartup(func() error {
-		h.readHosts()
-		return nil
-	})
-
-	c.OnShutdown(func() error {
-		close(parseChan)
-		_ = h.etcdClient.Close()
-		return nil
-	})
+	if h.apiAddr != "" {
+		a := newAPI(h.apiAddr, h.Hostsfile)
+		c.OnStartup(a.OnStartup)
+		c.OnRestart(a.OnFinalShutdown)
+		c.OnRestartFailed(a.OnStartup)
+		c.OnFinalShutdown(a.OnFinalShutdown)
+	}
 
 	dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
 		h.Next = next
@@ -65,16 +181,43 @@ func setup(c *caddy.Controller) error {
 	return nil
 }
 
+// OnStartup reads the hosts data and starts to watch it. The watch is only started on startup,
+// a setup that is thrown away when a reload fails doesn't leave it running.
+func (h *Hosts) OnStartup() error {
+	h.readHosts()
+	h.readHealth()
+	h.watchStop, h.watchDone = periodicHostsUpdate(h)
+	return nil
+}
+
+// OnShutdown stops the watch and closes the etcd clients.
+func (h *Hosts) OnShutdown() error {
+	if h.watchStop != nil {
+		// wait for the watch to stop before its client is closed
+		close(h.watchStop)
+		<-h.watchDone
+		h.watchStop = nil
+	}
+	_ = h.client().Close()
+	if h.etcdFallbackClient != nil {
+		_ = h.etcdFallbackClient.Close()
+	}
+	return nil
+}
+
 func hostsParse(c *caddy.Controller) (Hosts, error) {
 	h := Hosts{
 		Hostsfile: &Hostsfile{
-			hmap:    newMap(),
-			inline:  newMap(),
-			options: newOptions(),
+			hmap:      newMap(),
+			inline:    newMap(),
+			static:    newMap(),
+			options:   newOptions(),
+			lookupSRV: net.LookupSRV,
+			newClient: clientv3.New,
 		},
 	}
 
-	var inline []string
+	var inline, static []string
 	i := 0
 	for c.Next() {
 		if i > 0 {
@@ -89,8 +232,28 @@ func hostsParse(c *caddy.Controller) (Hosts, error) {
 			origins = args
 		}
 
+		seen := make(map[string]bool, len(origins))
+		for i := range origins {
+			zone, err := plugin.Host(origins[i]).MustNormalize()
+			if err != nil {
+				return h, c.Errf("invalid zone '%s': %s", origins[i], err.Error())
+			}
+			// international zones must match the names of the hosts data
+			zone = normalizeName(zone)
+			if seen[zone] {
+				return h, c.Errf("duplicate zone '%s'", origins[i])
+			}
+			seen[zone] = true
+			origins[i] = zone
+		}
+		// A query is answered from the first matching zone, so a zone inside another one
+		// would never be used as configured.
 		for i := range origins {
-			origins[i] = plugin.Host(origins[i]).Normalize()
+			for j := range origins {
+				if i != j && dns.IsSubDomain(origins[j], origins[i]) {
+					return h, c.Errf("zone '%s' overlaps zone '%s'", origins[i], origins[j])
+				}
+			}
 		}
 		h.Origins = origins
 
@@ -113,6 +276,29 @@ func hostsParse(c *caddy.Controller) (Hosts, error) {
 					return h, c.Errf("ttl provided is invalid")
 				}
 				h.options.ttl = uint32(ttl)
+			case "ttl_override":
+				remaining := c.RemainingArgs()
+				if len(remaining) == 0 {
+					return h, c.Errf("ttl_override needs at least one TYPE=TTL")
+				}
+				if h.options.ttlOverride == nil {
+					h.options.ttlOverride = make(map[uint16]uint32)
+				}
+				for _, o := range remaining {
+					i := strings.IndexByte(o, '=')
+					if i < 0 {
+						return h, c.Errf("invalid ttl_override '%s', it must be TYPE=TTL", o)
+					}
+					rrtype, ok := dns.StringToType[strings.ToUpper(o[:i])]
+					if !ok {
+						return h, c.Errf("invalid ttl_override '%s': unknown type '%s'", o, o[:i])
+					}
+					ttl, err := strconv.ParseUint(o[i+1:], 10, 32)
+					if err != nil || ttl > math.MaxInt32 {
+						return h, c.Errf("invalid ttl_override '%s': invalid ttl '%s'", o, o[i+1:])
+					}
+					h.options.ttlOverride[rrtype] = uint32(ttl)
+				}
 			case "tls":
 				remaining := c.RemainingArgs()
 				tlsConfig, err := mwtls.NewTLSConfigFromArgs(remaining...)
@@ -125,7 +311,33 @@ func hostsParse(c *caddy.Controller) (Hosts, error) {
 				if len(remaining) == 0 {
 					return h, c.ArgErr()
 				}
+				for i := range remaining {
+					ep, err := expandEnv(remaining[i])
+					if err != nil {
+						return h, c.Errf("invalid etcd endpoint '%s': %s", remaining[i], err.Error())
+					}
+					remaining[i] = ep
+				}
 				h.etcdEndpoints = remaining
+			case "discover_srv":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("discover_srv needs a SRV record name")
+				}
+				h.etcdDiscoverSRV = remaining[0]
+			case "fallback_endpoint":
+				remaining := c.RemainingArgs()
+				if len(remaining) == 0 {
+					return h, c.ArgErr()
+				}
+				for i := range remaining {
+					ep, err := expandEnv(remaining[i])
+					if err != nil {
+						return h, c.Errf("invalid etcd fallback endpoint '%s': %s", remaining[i], err.Error())
+					}
+					remaining[i] = ep
+				}
+				h.etcdFallbackEndpoints = remaining
 			case "timeout":
 				remaining := c.RemainingArgs()
 				if len(remaining) != 1 {
@@ -136,12 +348,97 @@ func hostsParse(c *caddy.Controller) (Hosts, error) {
 					return h, c.Errf("invalid duration for etcd client timeout '%s'", remaining[0])
 				}
 				h.etcdTimeout = timeout
+			case "dialtimeout":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("etcd dial timeout needs a duration")
+				}
+				timeout, err := time.ParseDuration(remaining[0])
+				if err != nil || timeout <= 0 {
+					return h, c.Errf("invalid duration for etcd dial timeout '%s'", remaining[0])
+				}
+				h.etcdDialTimeout = timeout
+			case "keepalive":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("etcd keepalive needs a duration")
+				}
+				keepalive, err := time.ParseDuration(remaining[0])
+				if err != nil || keepalive <= 0 {
+					return h, c.Errf("invalid duration for etcd keepalive '%s'", remaining[0])
+				}
+				h.etcdKeepAlive = keepalive
+			case "keepalivetimeout":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("etcd keepalive timeout needs a duration")
+				}
+				timeout, err := time.ParseDuration(remaining[0])
+				if err != nil || timeout <= 0 {
+					return h, c.Errf("invalid duration for etcd keepalive timeout '%s'", remaining[0])
+				}
+				h.etcdKeepAliveTimeout = timeout
+			case "retries":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("etcd retries needs a number")
+				}
+				retries, err := strconv.Atoi(remaining[0])
+				if err != nil || retries < 0 {
+					return h, c.Errf("invalid number of etcd retries '%s'", remaining[0])
+				}
+				h.etcdRetries = retries
+			case "retryinterval":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("etcd retry interval needs a duration")
+				}
+				interval, err := time.ParseDuration(remaining[0])
+				if err != nil || interval <= 0 {
+					return h, c.Errf("invalid duration for etcd retry interval '%s'", remaining[0])
+				}
+				h.etcdRetryInterval = interval
 			case "key":
 				remaining := c.RemainingArgs()
 				if len(remaining) != 1 {
 					return h, c.Errf("etcd hosts key needs a string")
 				}
-				h.etcdHostsKey = remaining[0]
+				key, err := expandEnv(remaining[0])
+				if err != nil {
+					return h, c.Errf("invalid etcd hosts key '%s': %s", remaining[0], err.Error())
+				}
+				if !validEtcdKey(key) {
+					return h, c.Errf("invalid etcd hosts key '%s', it must be an absolute path like /etcdhosts", key)
+				}
+				h.etcdHostsKey = key
+			case "prefix":
+				if len(c.RemainingArgs()) != 0 {
+					return h, c.ArgErr()
+				}
+				h.etcdPrefix = true
+			case "health_key":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("health_key needs an etcd key")
+				}
+				key, err := expandEnv(remaining[0])
+				if err != nil {
+					return h, c.Errf("invalid etcd health key '%s': %s", remaining[0], err.Error())
+				}
+				if !validEtcdKey(key) {
+					return h, c.Errf("invalid etcd health key '%s', it must be an absolute path like /etcdhosts-health", key)
+				}
+				h.etcdHealthKey = key
+			case "rev":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("etcd revision needs a number")
+				}
+				rev, err := strconv.ParseInt(remaining[0], 10, 64)
+				if err != nil || rev <= 0 {
+					return h, c.Errf("invalid etcd revision '%s'", remaining[0])
+				}
+				h.etcdRevision = rev
 			case "credentials":
 				remaining := c.RemainingArgs()
 				if len(remaining) == 0 {
@@ -151,6 +448,263 @@ func hostsParse(c *caddy.Controller) (Hosts, error) {
 					return h, c.Errf("credentials requires 2 arguments, username and password")
 				}
 				h.etcdUserName, h.etcdPassword = remaining[0], remaining[1]
+			case "chaos":
+				remaining := c.RemainingArgs()
+				if len(remaining) == 0 {
+					return h, c.Errf("chaos needs a version string")
+				}
+				h.options.chaosVersion = strings.Join(remaining, " ")
+			case "chaos_debug":
+				if len(c.RemainingArgs()) != 0 {
+					return h, c.ArgErr()
+				}
+				h.options.chaosDebug = true
+			case "dnssec_key":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("dnssec_key needs a key file")
+				}
+				d, err := newDNSSEC(remaining[0])
+				if err != nil {
+					return h, c.Errf("failed to load dnssec key: %s", err.Error())
+				}
+				// The DNSKEY is answered at its owner, which must be the apex of a zone.
+				if !containsString(h.Origins, d.key.Hdr.Name) {
+					return h, c.Errf("dnssec_key owner '%s' is not in zones %v", d.key.Hdr.Name, h.Origins)
+				}
+				h.dnssec = d
+			case "denylist":
+				remaining := c.RemainingArgs()
+				if len(remaining) < 2 {
+					return h, c.Errf("denylist needs a rcode and at least one name")
+				}
+				rcode, ok := dns.StringToRcode[strings.ToUpper(remaining[0])]
+				if !ok {
+					return h, c.Errf("unknown denylist rcode '%s'", remaining[0])
+				}
+				if h.options.denylist != nil && rcode != h.options.denyRcode {
+					return h, c.Errf("all denylist names must have the same rcode")
+				}
+				for _, name := range remaining[1:] {
+					name = normalizeName(name)
+					if _, ok := dns.IsDomainName(name); !ok {
+						return h, c.Errf("invalid denylist name '%s'", name)
+					}
+					h.options.denylist = append(h.options.denylist, name)
+				}
+				h.options.denyRcode = rcode
+			case "disable_types":
+				types, err := parseTypes(c.RemainingArgs())
+				if err != nil {
+					return h, c.Errf("invalid disable_types: %s", err.Error())
+				}
+				h.options.disabledTypes = types
+			case "allow_types":
+				remaining := c.RemainingArgs()
+				if len(remaining) < 2 {
+					return h, c.Errf("allow_types needs a zone and at least one type")
+				}
+				zone := normalizeName(remaining[0])
+				if plugin.Zones(h.Origins).Matches(zone) == "" {
+					return h, c.Errf("allow_types zone '%s' is not in zones %v", remaining[0], h.Origins)
+				}
+				types, err := parseTypes(remaining[1:])
+				if err != nil {
+					return h, c.Errf("invalid allow_types: %s", err.Error())
+				}
+				if h.options.allowedTypes == nil {
+					h.options.allowedTypes = make(map[string]map[uint16]bool)
+				}
+				h.options.allowedTypes[zone] = types
+			case "deny_types":
+				remaining := c.RemainingArgs()
+				if len(remaining) < 2 {
+					return h, c.Errf("deny_types needs a zone and at least one type")
+				}
+				zone := normalizeName(remaining[0])
+				if plugin.Zones(h.Origins).Matches(zone) == "" {
+					return h, c.Errf("deny_types zone '%s' is not in zones %v", remaining[0], h.Origins)
+				}
+				types, err := parseTypes(remaining[1:])
+				if err != nil {
+					return h, c.Errf("invalid deny_types: %s", err.Error())
+				}
+				if h.options.deniedTypes == nil {
+					h.options.deniedTypes = make(map[string]map[uint16]bool)
+				}
+				h.options.deniedTypes[zone] = types
+			case "soa_serial":
+				remaining := c.RemainingArgs()
+				switch {
+				case len(remaining) == 1 && remaining[0] == "auto":
+				case len(remaining) == 2 && remaining[0] == "manual":
+					serial, err := strconv.ParseUint(remaining[1], 10, 32)
+					if err != nil {
+						return h, c.Errf("invalid soa_serial '%s'", remaining[1])
+					}
+					h.options.soaSerialValue = uint32(serial)
+				default:
+					return h, c.Errf("soa_serial needs auto or manual and a serial")
+				}
+				h.options.soaSerial = remaining[0]
+			case "nameservers":
+				remaining := c.RemainingArgs()
+				if len(remaining) == 0 {
+					return h, c.Errf("nameservers needs at least one name")
+				}
+				for _, ns := range remaining {
+					name := plugin.Name(ns).Normalize()
+					if _, ok := dns.IsDomainName(name); !ok {
+						return h, c.Errf("invalid nameserver '%s'", ns)
+					}
+					h.options.nameservers = append(h.options.nameservers, name)
+				}
+			case "sortlist":
+				remaining := c.RemainingArgs()
+				if len(remaining) < 2 {
+					return h, c.Errf("sortlist needs a client network and at least one preferred network")
+				}
+				nets := make([]*net.IPNet, len(remaining))
+				for i := range remaining {
+					n, err := parseNet(remaining[i])
+					if err != nil {
+						return h, c.Errf("invalid sortlist network '%s'", remaining[i])
+					}
+					nets[i] = n
+				}
+				h.options.sortlist = append(h.options.sortlist, sortRule{client: nets[0], prefer: nets[1:]})
+			case "loadbalance":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("loadbalance needs a policy")
+				}
+				switch remaining[0] {
+				case "none", "sort", "round_robin":
+					h.options.loadbalance = remaining[0]
+				default:
+					return h, c.Errf("unknown loadbalance policy '%s', must be one of none, sort or round_robin", remaining[0])
+				}
+			case "unsupported":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("unsupported needs a response")
+				}
+				switch remaining[0] {
+				case "fallthrough", "notimp", "refused":
+					h.options.unsupported = remaining[0]
+				default:
+					return h, c.Errf("unknown unsupported response '%s', must be one of fallthrough, notimp or refused", remaining[0])
+				}
+			case "padding":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("padding needs a block size")
+				}
+				blockSize, err := strconv.Atoi(remaining[0])
+				if err != nil || blockSize <= 0 || blockSize > 512 {
+					return h, c.Errf("invalid padding block size '%s', it must be between 1 and 512", remaining[0])
+				}
+				h.options.padding = blockSize
+			case "querylog":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 || remaining[0] != "json" {
+					return h, c.Errf("querylog needs the json format")
+				}
+				h.options.queryLog = true
+			case "max_answers":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("max_answers needs a number")
+				}
+				max, err := strconv.Atoi(remaining[0])
+				if err != nil || max <= 0 {
+					return h, c.Errf("invalid max_answers '%s'", remaining[0])
+				}
+				h.options.maxAnswers = max
+			case "dns64":
+				remaining := c.RemainingArgs()
+				if len(remaining) > 1 {
+					return h, c.ArgErr()
+				}
+				prefix := dns64Prefix
+				if len(remaining) == 1 {
+					prefix = remaining[0]
+				}
+				_, n, err := net.ParseCIDR(prefix)
+				if err != nil || !validDNS64Prefix(n) {
+					return h, c.Errf("invalid dns64 prefix '%s', it must be an IPv6 network of length 32, 40, 48, 56, 64 or 96", prefix)
+				}
+				h.options.dns64 = n
+			case "minimal_any":
+				if len(c.RemainingArgs()) != 0 {
+					return h, c.ArgErr()
+				}
+				h.options.minimalAny = true
+			case "minimal":
+				if len(c.RemainingArgs()) != 0 {
+					return h, c.ArgErr()
+				}
+				h.options.minimal = true
+			case "static":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 3 {
+					return h, c.Errf("static needs a name, a type and an address")
+				}
+				ip := parseIP(remaining[2])
+				switch strings.ToUpper(remaining[1]) {
+				case "A":
+					if ip == nil || ipFamily(ip) != 1 {
+						return h, c.Errf("invalid static A address '%s'", remaining[2])
+					}
+				case "AAAA":
+					if ip == nil || ipFamily(ip) != 2 {
+						return h, c.Errf("invalid static AAAA address '%s'", remaining[2])
+					}
+				default:
+					return h, c.Errf("unsupported static type '%s', must be A or AAAA", remaining[1])
+				}
+				static = append(static, remaining[2]+" "+remaining[0])
+			case "fallback_file":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("fallback_file needs a file path")
+				}
+				h.fallbackFile = remaining[0]
+			case "update":
+				remaining := c.RemainingArgs()
+				if len(remaining) == 0 {
+					return h, c.Errf("update needs at least one allowed client network")
+				}
+				for _, r := range remaining {
+					n, err := parseNet(r)
+					if err != nil {
+						return h, c.Errf("invalid update network '%s'", r)
+					}
+					h.options.updateNets = append(h.options.updateNets, n)
+				}
+			case "ratelimit":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 2 {
+					return h, c.Errf("ratelimit needs qps and burst")
+				}
+				qps, err := strconv.Atoi(remaining[0])
+				if err != nil || qps <= 0 {
+					return h, c.Errf("invalid ratelimit qps '%s'", remaining[0])
+				}
+				burst, err := strconv.Atoi(remaining[1])
+				if err != nil || burst <= 0 {
+					return h, c.Errf("invalid ratelimit burst '%s'", remaining[1])
+				}
+				h.limiter = newRateLimiter(qps, burst)
+			case "api":
+				remaining := c.RemainingArgs()
+				if len(remaining) != 1 {
+					return h, c.Errf("api needs a listen address")
+				}
+				if _, _, err := net.SplitHostPort(remaining[0]); err != nil {
+					return h, c.Errf("invalid api listen address '%s': %s", remaining[0], err.Error())
+				}
+				h.apiAddr = remaining[0]
 			default:
 				if len(h.Fall.Zones) == 0 {
 					line := strings.Join(append([]string{c.Val()}, c.RemainingArgs()...), " ")
@@ -162,29 +716,139 @@ func hostsParse(c *caddy.Controller) (Hosts, error) {
 		}
 	}
 
+	// default load balancing, answers are shuffled when no sortlist rule matches
+	if h.options.loadbalance == "" {
+		h.options.loadbalance = "none"
+		if len(h.options.sortlist) > 0 {
+			h.options.loadbalance = "round_robin"
+		}
+	}
+
+	// queries of unsupported types go to the next plugin by default
+	if h.options.unsupported == "" {
+		h.options.unsupported = "fallthrough"
+	}
+
 	// default etcd key
 	if h.etcdHostsKey == "" {
 		h.etcdHostsKey = "/etcdhosts"
 	}
 
+	// the api and updates rewrite a single key, which isn't what is read in prefix mode
+	if h.etcdPrefix && (h.apiAddr != "" || len(h.options.updateNets) > 0) {
+		return h, c.Errf("api and update can not be used with prefix")
+	}
+
 	// default etcd client timeout
 	if h.etcdTimeout == 0 {
 		h.etcdTimeout = 3 * time.Second
 	}
 
-	cli, err := clientv3.New(clientv3.Config{
-		Username:    h.etcdUserName,
-		Password:    h.etcdPassword,
-		Endpoints:   h.etcdEndpoints,
-		DialTimeout: 5 * time.Second,
-		TLS:         h.etcdTLSConfig,
-	})
+	// default etcd retry interval
+	if h.etcdRetryInterval == 0 {
+		h.etcdRetryInterval = 100 * time.Millisecond
+	}
+
+	// default etcd client dial timeout and keepalive
+	if h.etcdDialTimeout == 0 {
+		h.etcdDialTimeout = 5 * time.Second
+	}
+	if h.etcdKeepAlive == 0 {
+		h.etcdKeepAlive = 30 * time.Second
+	}
+	if h.etcdKeepAliveTimeout == 0 {
+		h.etcdKeepAliveTimeout = 10 * time.Second
+	}
+
+	if h.etcdDiscoverSRV != "" {
+		if len(h.etcdEndpoints) > 0 {
+			return h, c.Errf("endpoint and discover_srv can not be used together")
+		}
+		endpoints, err := h.discoverEndpoints()
+		if err != nil {
+			return h, c.Errf("failed to discover etcd endpoints [%s]: %s", h.etcdDiscoverSRV, err.Error())
+		}
+		if len(endpoints) == 0 {
+			return h, c.Errf("no etcd endpoints found in SRV record [%s]", h.etcdDiscoverSRV)
+		}
+		h.etcdEndpoints = endpoints
+	}
+
+	cli, err := clientv3.New(h.etcdConfig())
 	if err != nil {
 		return h, c.Errf("failed to create etcd client: %s", err.Error())
 	}
 	h.etcdClient = cli
 
+	if len(h.etcdFallbackEndpoints) > 0 {
+		config := h.etcdConfig()
+		config.Endpoints = h.etcdFallbackEndpoints
+		fallback, err := clientv3.New(config)
+		if err != nil {
+			_ = cli.Close()
+			return h, c.Errf("failed to create etcd fallback client: %s", err.Error())
+		}
+		h.etcdFallbackClient = fallback
+	}
+
 	h.initInline(inline)
+	h.initStatic(static)
+	if err := h.readFallbackFile(); err != nil {
+		_ = h.etcdClient.Close()
+		if h.etcdFallbackClient != nil {
+			_ = h.etcdFallbackClient.Close()
+		}
+		return h, c.Errf("failed to read fallback file: %s", err.Error())
+	}
 
 	return h, nil
 }
+
+// expandEnv replaces ${VAR} and $VAR in s with the environment variables, unlike os.ExpandEnv
+// an unset variable is an error.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	expanded := os.Expand(s, func(name string) string {
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s not set", strings.Join(missing, ", "))
+	}
+	return expanded, nil
+}
+
+// validEtcdKey checks key is an absolute path without empty elements, e.g. /etcdhosts.
+func validEtcdKey(key string) bool {
+	if len(key) < 2 || key[0] != '/' {
+		return false
+	}
+	for _, e := range strings.Split(key[1:], "/") {
+		if e == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTypes parses query types given as separate or comma separated arguments, e.g. A,AAAA PTR.
+func parseTypes(args []string) (map[uint16]bool, error) {
+	types := make(map[uint16]bool)
+	for _, t := range strings.Split(strings.Join(args, ","), ",") {
+		if t == "" {
+			continue
+		}
+		qtype, ok := dns.StringToType[strings.ToUpper(t)]
+		if !ok {
+			return nil, fmt.Errorf("unknown type '%s'", t)
+		}
+		types[qtype] = true
+	}
+	if len(types) == 0 {
+		return nil, fmt.Errorf("at least one type is required")
+	}
+	return types, nil
+}
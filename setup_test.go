@@ -0,0 +1,285 @@
+package etcdhosts
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/caddy"
+	"go.etcd.io/etcd/clientv3"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/miekg/dns"
+)
+
+func TestSetupInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		errMsg string
+	}{
+		{"bad key", "etcdhosts example.org {\n key etcdhosts\n}", "invalid etcd hosts key"},
+		{"empty key element", "etcdhosts example.org {\n key /etcd//hosts\n}", "invalid etcd hosts key"},
+		{"invalid zone", "etcdhosts example..org", "invalid zone"},
+		{"duplicate zone", "etcdhosts example.org EXAMPLE.org.", "duplicate zone"},
+		{"overlapping zone", "etcdhosts example.org a.example.org", "overlaps zone"},
+		{"overlapping reverse zone", "etcdhosts 10.0.0.0/8 10.1.0.0/16", "overlaps zone"},
+	}
+
+	for _, tc := range tests {
+		c := caddy.NewTestController("dns", tc.input)
+		_, err := hostsParse(c)
+		if err == nil || !strings.Contains(err.Error(), tc.errMsg) {
+			t.Errorf("%s: expected an error containing %q, got %v", tc.name, tc.errMsg, err)
+		}
+	}
+}
+
+func TestShutdownLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	cli, _ := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	h := &Hosts{Hostsfile: newTestHostsfile(cli)}
+	h.etcdHealthKey = "/etcdhosts-health"
+
+	if err := h.OnStartup(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if ips := h.LookupStaticHostV4("www.example.org."); len(ips) != 2 {
+		t.Errorf("expected the hosts data to be read on startup, got %v", ips)
+	}
+	if err := h.OnShutdown(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+}
+
+// closed reports whether cli has been closed.
+func closed(cli *clientv3.Client) bool {
+	w := cli.Watcher.(*fakeWatcher)
+	w.Lock()
+	defer w.Unlock()
+	return w.closed && cli.Ctx().Err() != nil
+}
+
+func TestReload(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	start := func() (*Hosts, *api) {
+		cli, _ := newFakeClient(map[string]string{testHostsKey: hostsExample})
+		h := &Hosts{Hostsfile: newTestHostsfile(cli)}
+		a := newAPI(addr, h.Hostsfile)
+		if err := h.OnStartup(); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if err := a.OnStartup(); err != nil {
+			t.Fatalf("expected the api to listen, got %s", err)
+		}
+		return h, a
+	}
+
+	oldHosts, oldAPI := start()
+
+	// caddy calls the OnRestart hooks of the old instance, starts the new one and then shuts
+	// the old one down
+	if err := oldAPI.OnFinalShutdown(); err != nil {
+		t.Fatal(err)
+	}
+	newHosts, newAPI := start()
+	if err := oldHosts.OnShutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !closed(oldHosts.client()) {
+		t.Errorf("expected the etcd client of the old instance to be closed")
+	}
+	if closed(newHosts.client()) {
+		t.Errorf("expected the etcd client of the new instance to stay open")
+	}
+	resp, err := http.Get("http://" + addr + "/names")
+	if err != nil {
+		t.Fatalf("expected the api of the new instance to answer, got %s", err)
+	}
+	resp.Body.Close()
+	http.DefaultClient.CloseIdleConnections()
+
+	if err := newHosts.OnShutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if err := newAPI.OnFinalShutdown(); err != nil {
+		t.Fatal(err)
+	}
+	if !closed(newHosts.client()) {
+		t.Errorf("expected the etcd client of the new instance to be closed")
+	}
+}
+
+func TestShutdownClosesClients(t *testing.T) {
+	cli, _ := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	fallback, _ := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	h := &Hosts{Hostsfile: newTestHostsfile(cli)}
+	h.etcdFallbackClient = fallback
+
+	if err := h.OnShutdown(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if !closed(cli) {
+		t.Errorf("expected the etcd client to be closed")
+	}
+	if !closed(fallback) {
+		t.Errorf("expected the etcd fallback client to be closed")
+	}
+}
+
+func TestSetupEtcdConfig(t *testing.T) {
+	c := caddy.NewTestController("dns", `etcdhosts example.org {
+ endpoint http://127.0.0.1:2379
+ dialtimeout 2s
+ keepalive 20s
+ keepalivetimeout 7s
+}`)
+	h, err := hostsParse(c)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	defer h.OnShutdown()
+
+	config := h.etcdConfig()
+	if config.DialTimeout != 2*time.Second || config.DialKeepAliveTime != 20*time.Second || config.DialKeepAliveTimeout != 7*time.Second {
+		t.Errorf("expected dial timeout 2s, keepalive 20s and keepalive timeout 7s, got %s, %s and %s",
+			config.DialTimeout, config.DialKeepAliveTime, config.DialKeepAliveTimeout)
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("ETCDHOSTS_TEST_ENV", "prod")
+	defer os.Unsetenv("ETCDHOSTS_TEST_ENV")
+	os.Unsetenv("ETCDHOSTS_TEST_UNSET")
+
+	tests := []struct {
+		s, expected string
+		err         bool
+	}{
+		{"/etcdhosts", "/etcdhosts", false},
+		{"/etcdhosts/${ETCDHOSTS_TEST_ENV}", "/etcdhosts/prod", false},
+		{"/etcdhosts/$ETCDHOSTS_TEST_ENV/hosts", "/etcdhosts/prod/hosts", false},
+		{"/etcdhosts/${ETCDHOSTS_TEST_UNSET}", "", true},
+	}
+
+	for _, tc := range tests {
+		got, err := expandEnv(tc.s)
+		if tc.err != (err != nil) {
+			t.Errorf("%s: expected error %t, got %v", tc.s, tc.err, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.s, tc.expected, got)
+		}
+	}
+
+	c := caddy.NewTestController("dns", "etcdhosts example.org {\n endpoint http://127.0.0.1:2379\n key /etcdhosts/${ETCDHOSTS_TEST_ENV}\n}")
+	h, err := hostsParse(c)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	h.OnShutdown()
+	if h.etcdHostsKey != "/etcdhosts/prod" {
+		t.Errorf("expected the key /etcdhosts/prod, got %s", h.etcdHostsKey)
+	}
+
+	c = caddy.NewTestController("dns", "etcdhosts example.org {\n key /etcdhosts/${ETCDHOSTS_TEST_UNSET}\n}")
+	if _, err := hostsParse(c); err == nil || !strings.Contains(err.Error(), "ETCDHOSTS_TEST_UNSET") {
+		t.Errorf("expected an error naming the unset variable, got %v", err)
+	}
+}
+
+func TestSuperviseEtcd(t *testing.T) {
+	down, downKV := newFakeClient(map[string]string{testHostsKey: hostsExample})
+	downKV.fail, downKV.err = 100, status.Error(codes.Unavailable, "down")
+	h := Hosts{Hostsfile: newTestHostsfile(down)}
+	h.etcdTimeout = 10 * time.Millisecond
+
+	// the endpoint is back when the client is replaced
+	var up *clientv3.Client
+	h.newClient = func(clientv3.Config) (*clientv3.Client, error) {
+		up, _ = newFakeClient(map[string]string{testHostsKey: hostsExample})
+		return up, nil
+	}
+
+	h.readHosts()
+	b := &reconnectBackoff{interval: minReconnectBackoff, last: time.Now()}
+	for i := 0; i < reconnectFailures; i++ {
+		if h.superviseEtcd(b) {
+			t.Fatalf("expected no reconnect after %d failures within the backoff", i+2)
+		}
+	}
+	if up != nil {
+		t.Fatalf("expected no new client within the backoff")
+	}
+
+	b.last = time.Now().Add(-minReconnectBackoff)
+	if !h.superviseEtcd(b) || h.client() != up {
+		t.Fatalf("expected the client to be replaced")
+	}
+	if b.interval != 2*minReconnectBackoff {
+		t.Errorf("expected the backoff to double, got %s", b.interval)
+	}
+
+	h.readHosts()
+	if h.etcdDown() || len(h.LookupStaticHostV4("www.example.org.")) != 2 {
+		t.Errorf("expected the hosts data to be read from the new client")
+	}
+	if h.superviseEtcd(b) || b.interval != minReconnectBackoff {
+		t.Errorf("expected the backoff to be reset once etcd is reachable, got %s", b.interval)
+	}
+
+	// the old client is closed once its requests had the time to finish
+	for i := 0; i < 100 && !closed(down); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !closed(down) {
+		t.Errorf("expected the old client to be closed")
+	}
+	up.Close()
+}
+
+func TestSetupTTLOverride(t *testing.T) {
+	c := caddy.NewTestController("dns", `etcdhosts example.org {
+ endpoint http://127.0.0.1:2379
+ ttl 300
+ ttl_override A=30 NS=600
+ nameservers ns1.example.org
+}`)
+	h, err := hostsParse(c)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	defer h.OnShutdown()
+	h.hmap = h.parse(strings.NewReader(hostsExample))
+
+	rec := serve(t, h, "www.example.org.", dns.TypeA)
+	if len(rec.Msg.Answer) == 0 || len(rec.Msg.Ns) == 0 {
+		t.Fatalf("expected an answer with NS records, got %s", rec.Msg)
+	}
+	if ttl := rec.Msg.Answer[0].Header().Ttl; ttl != 30 {
+		t.Errorf("expected the A TTL 30, got %d", ttl)
+	}
+	if ttl := rec.Msg.Ns[0].Header().Ttl; ttl != 600 {
+		t.Errorf("expected the NS TTL 600, got %d", ttl)
+	}
+	// types without an override keep the ttl
+	if rec := serve(t, h, "www.example.org.", dns.TypeAAAA); len(rec.Msg.Answer) == 0 || rec.Msg.Answer[0].Header().Ttl != 300 {
+		t.Errorf("expected the AAAA TTL 300, got %v", rec.Msg.Answer)
+	}
+}
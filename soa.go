@@ -0,0 +1,49 @@
+package etcdhosts
+
+import (
+	"github.com/miekg/dns"
+)
+
+// soa returns the SOA record synthesized for zone, by default the version of the hosts data is
+// used as serial so secondaries and caches see every change. The first of nameservers is the primary
+// nameserver when it is set.
+func (h *Hostsfile) soa(zone string, ttl uint32) []dns.RR {
+	h.RLock()
+	serial := uint32(h.etcdKeyVersion)
+	switch h.options.soaSerial {
+	case "auto":
+		// revisions beyond 32 bits wrap around, which serial number arithmetic allows for
+		serial = uint32(h.etcdModRevision)
+	case "manual":
+		serial = h.options.soaSerialValue
+	}
+	h.RUnlock()
+
+	mname := "ns.dns." + zone
+	if len(h.options.nameservers) > 0 {
+		mname = h.options.nameservers[0]
+	}
+
+	return []dns.RR{&dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: ttl},
+		Ns:      mname,
+		Mbox:    "hostmaster." + zone,
+		Serial:  serial,
+		Refresh: 7200,
+		Retry:   1800,
+		Expire:  86400,
+		Minttl:  ttl,
+	}}
+}
+
+// ns returns the NS records of the nameservers directive for zone.
+func (h *Hostsfile) ns(zone string, ttl uint32) []dns.RR {
+	rrs := make([]dns.RR, len(h.options.nameservers))
+	for i, name := range h.options.nameservers {
+		rrs[i] = &dns.NS{
+			Hdr: dns.RR_Header{Name: zone, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: ttl},
+			Ns:  name,
+		}
+	}
+	return rrs
+}
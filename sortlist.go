@@ -0,0 +1,65 @@
+package etcdhosts
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sort"
+)
+
+// sortRule orders the addresses answered to clients in client, addresses in the first
+// network of prefer come first, then those in the second one and so on.
+type sortRule struct {
+	client *net.IPNet
+	prefer []*net.IPNet
+}
+
+// orderIPs orders ips by the first rule that matches client, when no rule matches they are
+// ordered by the load balancing policy:
+//   - none keeps the order of the hosts data
+//   - sort sorts by address
+//   - round_robin shuffles them
+func orderIPs(rules []sortRule, policy string, client net.IP, ips []net.IP) []net.IP {
+	if len(ips) < 2 {
+		return ips
+	}
+
+	for _, rule := range rules {
+		if !rule.client.Contains(client) {
+			continue
+		}
+		rank := func(ip net.IP) int {
+			for i, n := range rule.prefer {
+				if n.Contains(ip) {
+					return i
+				}
+			}
+			return len(rule.prefer)
+		}
+		sort.SliceStable(ips, func(i, j int) bool { return rank(ips[i]) < rank(ips[j]) })
+		return ips
+	}
+
+	switch policy {
+	case "sort":
+		sort.Slice(ips, func(i, j int) bool { return bytes.Compare(ips[i].To16(), ips[j].To16()) < 0 })
+	case "round_robin":
+		rand.Shuffle(len(ips), func(i, j int) { ips[i], ips[j] = ips[j], ips[i] })
+	}
+	return ips
+}
+
+// parseNet parses an address or a CIDR network, a single address is a /32 or /128 network.
+func parseNet(s string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		return n, nil
+	}
+	ip := parseIP(s)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "CIDR address", Text: s}
+	}
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
@@ -0,0 +1,30 @@
+package etcdhosts
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestServeDNSLoadbalanceSort(t *testing.T) {
+	// the same addresses stored in two orders
+	for _, data := range []string{
+		"10.0.0.3 www.example.org\n10.0.0.1 www.example.org\n10.0.0.20 www.example.org\n10.0.0.2 www.example.org\n",
+		"10.0.0.20 www.example.org\n10.0.0.2 www.example.org\n10.0.0.1 www.example.org\n10.0.0.3 www.example.org\n",
+	} {
+		h := newTestHosts(data)
+		h.options.loadbalance = "sort"
+
+		rec := serve(t, h, "www.example.org.", dns.TypeA)
+		expected := []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.20"}
+		if len(rec.Msg.Answer) != len(expected) {
+			t.Fatalf("expected %d answers, got %v", len(expected), rec.Msg.Answer)
+		}
+		for i, rr := range rec.Msg.Answer {
+			if ip := rr.(*dns.A).A.String(); ip != expected[i] {
+				t.Errorf("expected %v in order, got %v", expected, rec.Msg.Answer)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package etcdhosts
+
+import (
+	"context"
+	"net"
+
+	"github.com/coredns/coredns/plugin"
+	"github.com/coredns/coredns/request"
+
+	"github.com/miekg/dns"
+)
+
+// hostsEdit is a change of the addresses of a name in the hosts data.
+type hostsEdit struct {
+	name   string
+	family int
+	remove func(net.IP) bool
+	add    []net.IP
+}
+
+// hostsPrereq is a prerequisite on the existence of a name in the hosts data.
+type hostsPrereq struct {
+	name  string
+	inUse bool
+}
+
+// serveUpdate applies a RFC 2136 dynamic update of A and AAAA records to the hosts data
+// stored in etcd and returns the response code. Only the prerequisites on the existence
+// of a name are supported.
+func (h Hosts) serveUpdate(ctx context.Context, state request.Request) int {
+	r := state.Req
+
+	client := net.ParseIP(state.IP())
+	allowed := false
+	for _, n := range h.options.updateNets {
+		if n.Contains(client) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return dns.RcodeRefused
+	}
+	// Without TSIG the client address is the only credential, which is easily spoofed over UDP,
+	// so updates must come over TCP.
+	if state.Proto() != "tcp" {
+		return dns.RcodeRefused
+	}
+
+	if len(r.Question) != 1 || r.Question[0].Qtype != dns.TypeSOA {
+		return dns.RcodeFormatError
+	}
+	zone := plugin.Name(r.Question[0].Name).Normalize()
+	if !containsString(h.Origins, zone) {
+		return dns.RcodeNotAuth
+	}
+
+	// Prerequisites are checked against the hosts data the update is written to, not against
+	// the inline and static entries that are answered too.
+	var prereqs []hostsPrereq
+	for _, rr := range r.Answer {
+		hdr := rr.Header()
+		name := normalizeName(hdr.Name)
+		if !dns.IsSubDomain(zone, name) {
+			return dns.RcodeNotZone
+		}
+		switch {
+		case hdr.Class == dns.ClassANY && hdr.Rrtype == dns.TypeANY:
+			prereqs = append(prereqs, hostsPrereq{name: name, inUse: true})
+		case hdr.Class == dns.ClassNONE && hdr.Rrtype == dns.TypeANY:
+			prereqs = append(prereqs, hostsPrereq{name: name, inUse: false})
+		default:
+			return dns.RcodeNotImplemented
+		}
+	}
+
+	// Check all updates before writing any of them.
+	var edits []hostsEdit
+	for _, rr := range r.Ns {
+		hdr := rr.Header()
+		name := normalizeName(hdr.Name)
+		if !dns.IsSubDomain(zone, name) {
+			return dns.RcodeNotZone
+		}
+
+		var ip net.IP
+		family := 0
+		switch rr := rr.(type) {
+		case *dns.A:
+			ip, family = rr.A, 1
+		case *dns.AAAA:
+			ip, family = rr.AAAA, 2
+		}
+
+		switch hdr.Class {
+		case dns.ClassINET:
+			// add an address
+			if ip == nil {
+				return dns.RcodeRefused
+			}
+			edits = append(edits, hostsEdit{name: name, family: family, remove: ip.Equal, add: []net.IP{ip}})
+		case dns.ClassANY:
+			// delete a RRset or all RRsets of a name
+			all := func(net.IP) bool { return true }
+			switch hdr.Rrtype {
+			case dns.TypeANY:
+				edits = append(edits, hostsEdit{name: name, family: 1, remove: all}, hostsEdit{name: name, family: 2, remove: all})
+			case dns.TypeA, dns.TypeAAAA:
+				edits = append(edits, hostsEdit{name: name, family: family, remove: all})
+			default:
+				return dns.RcodeRefused
+			}
+		case dns.ClassNONE:
+			// delete an address
+			if ip == nil {
+				return dns.RcodeRefused
+			}
+			edits = append(edits, hostsEdit{name: name, family: family, remove: ip.Equal})
+		default:
+			return dns.RcodeFormatError
+		}
+	}
+	rcode := dns.RcodeSuccess
+	err := h.modifyHosts(ctx, func(data []byte) ([]byte, bool) {
+		for _, p := range prereqs {
+			if inUse := nameInUse(data, p.name); inUse != p.inUse {
+				rcode = dns.RcodeNameError
+				if inUse {
+					rcode = dns.RcodeYXDomain
+				}
+				return data, false
+			}
+		}
+
+		changed := false
+		for _, e := range edits {
+			var removed bool
+			data, removed = editHosts(data, e.name, e.family, e.remove, e.add)
+			changed = changed || removed || len(e.add) > 0
+		}
+		return data, changed
+	})
+	if err != nil {
+		log.Errorf("failed to update etcd key [%s]: %s", h.etcdHostsKey, err.Error())
+		return dns.RcodeServerFailure
+	}
+	return rcode
+}
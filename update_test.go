@@ -0,0 +1,107 @@
+package etcdhosts
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/coredns/coredns/plugin/test"
+
+	"github.com/miekg/dns"
+)
+
+// newTestUpdateHosts returns a Hosts that reads and writes the hosts data in etcd kv and accepts
+// updates from the address of test.ResponseWriter.
+func newTestUpdateHosts(t *testing.T, data string) (Hosts, *fakeKV) {
+	cli, kv := newFakeClient(map[string]string{testHostsKey: data})
+	h := newTestHosts("")
+	h.etcdClient, h.etcdHostsKey, h.etcdTimeout = cli, testHostsKey, time.Second
+	_, n, err := net.ParseCIDR("10.240.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.options.updateNets = []*net.IPNet{n}
+	h.readHosts()
+	return h, kv
+}
+
+// update sends m to h over TCP, or UDP, and returns the rcode of the reply.
+func update(t *testing.T, h Hosts, m *dns.Msg, tcp bool) int {
+	t.Helper()
+	rec := dnstest.NewRecorder(&test.ResponseWriter{TCP: tcp})
+	if _, err := h.ServeDNS(context.Background(), rec, m); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	return rec.Msg.Rcode
+}
+
+func TestServeDNSUpdate(t *testing.T) {
+	h, kv := newTestUpdateHosts(t, hostsExample)
+
+	m := new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.Insert([]dns.RR{test.A("new.example.org. 300 IN A 10.0.0.9")})
+	if rcode := update(t, h, m, true); rcode != dns.RcodeSuccess {
+		t.Fatalf("expected the update to succeed, got %s", dns.RcodeToString[rcode])
+	}
+
+	// the watch reads the hosts data again after the write
+	h.readHosts()
+	rec := serve(t, h, "new.example.org.", dns.TypeA)
+	if rec.Msg == nil || len(rec.Msg.Answer) != 1 || rec.Msg.Answer[0].(*dns.A).A.String() != "10.0.0.9" {
+		t.Errorf("expected the new record to resolve, got %v", rec.Msg)
+	}
+
+	// updates over UDP are refused
+	m = new(dns.Msg)
+	m.SetUpdate("example.org.")
+	m.Insert([]dns.RR{test.A("udp.example.org. 300 IN A 10.0.0.10")})
+	if rcode := update(t, h, m, false); rcode != dns.RcodeRefused {
+		t.Errorf("expected an update over UDP to be refused, got %s", dns.RcodeToString[rcode])
+	}
+	if strings.Contains(string(kv.kvs[testHostsKey].Value), "udp.example.org") {
+		t.Errorf("expected the update over UDP not to be written")
+	}
+}
+
+func TestServeDNSUpdatePrereq(t *testing.T) {
+	tests := []struct {
+		name  string
+		used  bool
+		rcode int
+	}{
+		{"www.example.org.", true, dns.RcodeSuccess},
+		{"www.example.org.", false, dns.RcodeYXDomain},
+		{"missing.example.org.", false, dns.RcodeSuccess},
+		{"missing.example.org.", true, dns.RcodeNameError},
+		// inline entries are answered but are not in the hosts data that is updated
+		{"inline.example.org.", true, dns.RcodeNameError},
+	}
+
+	for _, tc := range tests {
+		h, kv := newTestUpdateHosts(t, hostsExample)
+		h.inline = h.parse(strings.NewReader("10.0.0.8 inline.example.org"))
+		version := kv.kvs[testHostsKey].Version
+
+		m := new(dns.Msg)
+		m.SetUpdate("example.org.")
+		rr := []dns.RR{&dns.ANY{Hdr: dns.RR_Header{Name: tc.name}}}
+		if tc.used {
+			m.NameUsed(rr)
+		} else {
+			m.NameNotUsed(rr)
+		}
+		m.Insert([]dns.RR{test.A("new.example.org. 300 IN A 10.0.0.9")})
+
+		if rcode := update(t, h, m, true); rcode != tc.rcode {
+			t.Errorf("%s in use %t: expected %s, got %s", tc.name, tc.used, dns.RcodeToString[tc.rcode], dns.RcodeToString[rcode])
+		}
+		written := kv.kvs[testHostsKey].Version != version
+		if written != (tc.rcode == dns.RcodeSuccess) {
+			t.Errorf("%s in use %t: expected written %t, got %t", tc.name, tc.used, tc.rcode == dns.RcodeSuccess, written)
+		}
+	}
+}
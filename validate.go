@@ -0,0 +1,77 @@
+package etcdhosts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+
+	"go.etcd.io/etcd/clientv3"
+
+	"github.com/coredns/coredns/plugin"
+
+	"github.com/miekg/dns"
+)
+
+// Problem is an entry of the hosts data that is ignored when it is parsed.
+type Problem struct {
+	// line number in the hosts data, starting at 1
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("line %d, %s: %s", p.Line, p.Reason, p.Text)
+}
+
+// ValidateHosts gets the hosts data stored at key and reports every entry that etcdhosts would
+// ignore when serving the given zones.
+func ValidateHosts(ctx context.Context, client *clientv3.Client, key string, origins []string) ([]Problem, error) {
+	getResp, err := client.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(getResp.Kvs) == 0 {
+		return nil, fmt.Errorf("etcd key [%s] not found", key)
+	}
+
+	zones := make([]string, len(origins))
+	for i := range origins {
+		zones[i] = plugin.Name(origins[i]).Normalize()
+	}
+	return validateHosts(getResp.Kvs[0].Value, zones), nil
+}
+
+// validateHosts reports the entries of the hosts data that parse ignores.
+func validateHosts(data []byte, origins []string) []Problem {
+	var problems []Problem
+
+	n := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		n++
+		text := string(bytes.TrimSpace(scanner.Bytes()))
+
+		addr, names, err := parseLine(scanner.Bytes())
+		if err != nil {
+			problems = append(problems, Problem{Line: n, Text: text, Reason: err.Error()})
+			continue
+		}
+		if addr == nil {
+			continue
+		}
+
+		for _, name := range names {
+			fqdn := normalizeName(string(name))
+			if _, ok := dns.IsDomainName(fqdn); !ok {
+				problems = append(problems, Problem{Line: n, Text: text, Reason: fmt.Sprintf("invalid host name '%s'", name)})
+				continue
+			}
+			if plugin.Zones(origins).Matches(fqdn) == "" {
+				problems = append(problems, Problem{Line: n, Text: text, Reason: fmt.Sprintf("host name '%s' not in zones", name)})
+			}
+		}
+	}
+	return problems
+}
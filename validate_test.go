@@ -0,0 +1,37 @@
+package etcdhosts
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestValidateHosts(t *testing.T) {
+	data := `# good lines
+10.0.0.1 example.org www.example.org
+fd00::1 www.example.org
+10.0.0.2
+example.org 10.0.0.3
+10.0.0.4 www.example.net
+10.0.0.5 www..example.org
+`
+	cli, _ := newFakeClient(map[string]string{testHostsKey: data})
+
+	problems, err := ValidateHosts(context.Background(), cli, testHostsKey, []string{"example.org"})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := []Problem{
+		{Line: 4, Text: "10.0.0.2", Reason: "missing host name"},
+		{Line: 5, Text: "example.org 10.0.0.3", Reason: "invalid address 'example.org'"},
+		{Line: 6, Text: "10.0.0.4 www.example.net", Reason: "host name 'www.example.net' not in zones"},
+		{Line: 7, Text: "10.0.0.5 www..example.org", Reason: "invalid host name 'www..example.org'"},
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected problems %v, got %v", expected, problems)
+	}
+
+	if _, err := ValidateHosts(context.Background(), cli, "/missing", []string{"example.org"}); err == nil {
+		t.Errorf("expected an error for a missing key")
+	}
+}
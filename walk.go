@@ -0,0 +1,45 @@
+package etcdhosts
+
+import (
+	"context"
+
+	"go.etcd.io/etcd/clientv3"
+)
+
+// WalkHosts calls fn with every key and value under prefix in key order. The keys are read in
+// pages of pageSize keys at the revision of the first page, so a large sharded hosts data is
+// never held in memory at once and is still seen as a consistent snapshot. Walking stops at the
+// first error returned by fn.
+func WalkHosts(ctx context.Context, client *clientv3.Client, prefix string, pageSize int64, fn func(key string, value []byte) error) error {
+	end := clientv3.GetPrefixRangeEnd(prefix)
+	key := prefix
+	var rev int64
+	for {
+		opts := []clientv3.OpOption{
+			clientv3.WithRange(end),
+			clientv3.WithLimit(pageSize),
+			clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend),
+		}
+		if rev > 0 {
+			opts = append(opts, clientv3.WithRev(rev))
+		}
+		getResp, err := client.Get(ctx, key, opts...)
+		if err != nil {
+			return err
+		}
+		if rev == 0 {
+			rev = getResp.Header.Revision
+		}
+
+		for _, kv := range getResp.Kvs {
+			if err := fn(string(kv.Key), kv.Value); err != nil {
+				return err
+			}
+		}
+		if !getResp.More || len(getResp.Kvs) == 0 {
+			return nil
+		}
+		// continue right after the last key of this page
+		key = string(getResp.Kvs[len(getResp.Kvs)-1].Key) + "\x00"
+	}
+}
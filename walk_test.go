@@ -0,0 +1,47 @@
+package etcdhosts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWalkHosts(t *testing.T) {
+	kvs := map[string]string{"/other": "10.0.0.1 other.example.org"}
+	for i := 0; i < 25; i++ {
+		kvs[fmt.Sprintf("%s/%02d", testHostsKey, i)] = fmt.Sprintf("10.0.0.%d host%d.example.org", i, i)
+	}
+	cli, kv := newFakeClient(kvs)
+
+	var keys []string
+	err := WalkHosts(context.Background(), cli, testHostsKey+"/", 10, func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(keys) != 25 {
+		t.Fatalf("expected 25 keys, got %d", len(keys))
+	}
+	for i, key := range keys {
+		if expected := fmt.Sprintf("%s/%02d", testHostsKey, i); key != expected {
+			t.Errorf("expected key %s at %d, got %s", expected, i, key)
+		}
+	}
+	if kv.gets != 3 {
+		t.Errorf("expected 3 pages, got %d", kv.gets)
+	}
+
+	// walking stops at the first error
+	stop := errors.New("stop")
+	n := 0
+	err = WalkHosts(context.Background(), cli, testHostsKey+"/", 10, func(key string, value []byte) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("expected the walk to stop at the first key, got %v after %d keys", err, n)
+	}
+}